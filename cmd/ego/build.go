@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benbjohnson/ego"
+)
+
+// diagnosticPosRegexp matches a position in a template or generated file.
+var diagnosticPosRegexp = regexp.MustCompile(`([^\s:]+\.ego(?:\.go)?):(\d+):(\d+)`)
+
+// runGoTool runs a go command, such as "go build" or "go vet", and rewrites
+// the positions in its diagnostics to the template code they came from.
+// Option flags, such as -errors, may be passed before the go command's
+// arguments so templates are generated as they were by the main command.
+func runGoTool(name string, args []string) error {
+	opts, args, err := parseOptionArgs(args)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", append([]string{name}, args...)...)
+	cmd.Stdin, cmd.Stdout = os.Stdin, os.Stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	} else if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	r := newDiagnosticRewriter(opts)
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		fmt.Fprintln(os.Stderr, r.rewrite(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// parseOptionArgs parses the option flags at the start of args & returns
+// the options along with the remaining arguments.
+func parseOptionArgs(args []string) (ego.Options, []string, error) {
+	var opts ego.Options
+	fs := flag.NewFlagSet("ego", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	optionFlags(fs, &opts)
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		name := strings.SplitN(strings.TrimLeft(args[0], "-"), "=", 2)[0]
+		if fs.Lookup(name) == nil {
+			break
+		} else if err := fs.Parse(args[:1]); err != nil {
+			return opts, nil, err
+		}
+		args = args[1:]
+	}
+	return opts, args, nil
+}
+
+// diagnosticRewriter rewrites positions in Go tool output using the
+// source maps of the templates.
+type diagnosticRewriter struct {
+	opts     ego.Options               // options enabled for every template
+	resolver *ego.Resolver             // reads the packages of components
+	maps     map[string]*ego.SourceMap // by template path; nil if unavailable
+}
+
+func newDiagnosticRewriter(opts ego.Options) *diagnosticRewriter {
+	return &diagnosticRewriter{
+		opts:     opts,
+		resolver: ego.NewResolver(),
+		maps:     make(map[string]*ego.SourceMap),
+	}
+}
+
+// rewrite returns line with each generated position replaced by the
+// position in the template. Positions which cannot be mapped are unchanged.
+func (r *diagnosticRewriter) rewrite(line string) string {
+	return diagnosticPosRegexp.ReplaceAllStringFunc(line, func(s string) string {
+		m := diagnosticPosRegexp.FindStringSubmatch(s)
+		lineNo, _ := strconv.Atoi(m[2])
+		colNo, _ := strconv.Atoi(m[3])
+
+		// Positions in the generated file are reported when no //line
+		// directive applies. Otherwise they are relative to the directives.
+		path := strings.TrimSuffix(m[1], ".go")
+		sm := r.sourceMap(path)
+		if sm == nil {
+			return s
+		}
+
+		var pos ego.Pos
+		var ok bool
+		if strings.HasSuffix(m[1], ".go") {
+			pos, ok = sm.Lookup(lineNo, colNo)
+		} else {
+			pos, ok = sm.LookupAdjusted(lineNo, colNo)
+		}
+		if !ok {
+			return s
+		}
+		return fmt.Sprintf("%s:%d:%d", path, pos.LineNo, pos.ColNo)
+	})
+}
+
+// sourceMap returns the source map for the template at path.
+// Returns nil if the template cannot be parsed or generated, or if the
+// generated file does not match the template, such as when it is stale or
+// was generated with other options. Positions in the file would otherwise be
+// mapped to the wrong places in the template.
+func (r *diagnosticRewriter) sourceMap(path string) *ego.SourceMap {
+	if sm, ok := r.maps[path]; ok {
+		return sm
+	}
+	sm := r.generateSourceMap(path)
+	r.maps[path] = sm
+	return sm
+}
+
+func (r *diagnosticRewriter) generateSourceMap(path string) *ego.SourceMap {
+	tmpl, err := ego.ParseFile(path)
+	if err != nil {
+		return nil
+	}
+	mergeOptions(tmpl, r.opts)
+	tmpl.Resolver = r.resolver
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		return nil
+	} else if existing, err := ioutil.ReadFile(path + ".go"); err != nil || !bytes.Equal(existing, buf.Bytes()) {
+		return nil
+	}
+
+	sm, _ := tmpl.SourceMap()
+	return sm
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure option flags are parsed up to the go command's arguments.
+func TestParseOptionArgs(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		opts ego.Options
+		rest []string
+		err  string
+	}{
+		{name: "None", args: []string{"-v", "./..."}, rest: []string{"-v", "./..."}},
+		{name: "Options", args: []string{"-errors", "--static", "-construct=true", "-v", "./..."}, opts: ego.Options{ReturnErrors: true, StaticText: true, Construct: true}, rest: []string{"-v", "./..."}},
+		{name: "AfterArgs", args: []string{"./...", "-errors"}, rest: []string{"./...", "-errors"}},
+		{name: "Invalid", args: []string{"-errors=maybe"}, err: `invalid boolean value "maybe" for -errors: parse error`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, rest, err := parseOptionArgs(tt.args)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if opts != tt.opts {
+				t.Fatalf("unexpected options: %+v", opts)
+			} else if !reflect.DeepEqual(rest, tt.rest) {
+				t.Fatalf("unexpected args: %q", rest)
+			}
+		})
+	}
+}
+
+// Ensure diagnostics are only mapped to templates whose generated file
+// matches the template & options.
+func TestDiagnosticRewriter_Rewrite(t *testing.T) {
+	const text = "<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\n<p><%= nmae %></p>\n<% } %>\n"
+	generate := func(t *testing.T, path string, opts ego.Options) string {
+		t.Helper()
+		tmpl, err := ego.ParseFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mergeOptions(tmpl, opts)
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(path+".go", buf.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		// Return the diagnostic for the identifier in the generated code.
+		for i, line := range strings.Split(buf.String(), "\n") {
+			if j := strings.Index(line, "nmae"); j != -1 {
+				return fmt.Sprintf("%s.go:%d:%d: undefined: nmae", path, i+1, j+1)
+			}
+		}
+		t.Fatal("identifier not found")
+		return ""
+	}
+
+	for _, tt := range []struct {
+		name  string
+		gen   ego.Options // options used to generate the file
+		opts  ego.Options // options passed to the rewriter
+		stale bool        // template changed after generation
+		ok    bool
+	}{
+		{name: "OK", ok: true},
+		{name: "Options", gen: ego.Options{ReturnErrors: true}, opts: ego.Options{ReturnErrors: true}, ok: true},
+		{name: "MissingOptions", gen: ego.Options{ReturnErrors: true}},
+		{name: "ExtraOptions", opts: ego.Options{ReturnErrors: true}},
+		{name: "Stale", stale: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tmpl.ego")
+			if err := ioutil.WriteFile(path, []byte(text), 0666); err != nil {
+				t.Fatal(err)
+			}
+			line := generate(t, path, tt.gen)
+			if tt.stale {
+				if err := ioutil.WriteFile(path, []byte("\n"+text), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := line
+			if tt.ok {
+				want = path + ":3:8: undefined: nmae"
+			}
+			if got := newDiagnosticRewriter(tt.opts).rewrite(line); got != want {
+				t.Fatalf("rewrite()=%q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Ensure unified diffs match the output of "diff -u".
+func TestUnifiedDiff(t *testing.T) {
+	// Lines "1" through "20" with changes at the given lines.
+	lines := func(changes map[int]string) string {
+		var buf strings.Builder
+		for i := 1; i <= 20; i++ {
+			if line, ok := changes[i]; !ok {
+				buf.WriteString(strings.Repeat("x", i) + "\n")
+			} else if line != "" {
+				buf.WriteString(line + "\n")
+			}
+		}
+		return buf.String()
+	}
+
+	for _, tt := range []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "Identical", a: "a\nb\n", b: "a\nb\n", want: ""},
+		{name: "Insert", a: "a\nb\n", b: "a\nx\nb\n", want: "--- a\n+++ b\n@@ -1,2 +1,3 @@\n a\n+x\n b\n"},
+		{name: "Delete", a: "a\nb\nc\n", b: "a\nc\n", want: "--- a\n+++ b\n@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{name: "Replace", a: "a\nb\nc\n", b: "a\nx\nc\n", want: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{name: "FromEmpty", a: "", b: "a\n", want: "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n"},
+		{name: "ToEmpty", a: "a\n", b: "", want: "--- a\n+++ b\n@@ -1 +0,0 @@\n-a\n"},
+		{name: "RemoveTrailingNewline", a: "a\nb\n", b: "a\nb", want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n"},
+		{name: "NoTrailingNewline", a: "a\nb", b: "a\nc", want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+		{
+			name: "SeparateHunks",
+			a:    lines(nil),
+			b:    lines(map[int]string{2: "two", 18: "eighteen"}),
+			want: "--- a\n+++ b\n" +
+				"@@ -1,5 +1,5 @@\n x\n-xx\n+two\n xxx\n xxxx\n xxxxx\n" +
+				"@@ -15,6 +15,6 @@\n " + strings.Repeat("x", 15) + "\n " + strings.Repeat("x", 16) + "\n " + strings.Repeat("x", 17) + "\n-" + strings.Repeat("x", 18) + "\n+eighteen\n " + strings.Repeat("x", 19) + "\n " + strings.Repeat("x", 20) + "\n",
+		},
+		{
+			name: "MergedHunks",
+			a:    lines(nil),
+			b:    lines(map[int]string{5: "", 11: "eleven"}),
+			want: "--- a\n+++ b\n@@ -2,13 +2,12 @@\n" +
+				" xx\n xxx\n xxxx\n-xxxxx\n xxxxxx\n xxxxxxx\n xxxxxxxx\n xxxxxxxxx\n xxxxxxxxxx\n-xxxxxxxxxxx\n+eleven\n xxxxxxxxxxxx\n xxxxxxxxxxxxx\n xxxxxxxxxxxxxx\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("a", "b", []byte(tt.a), []byte(tt.b)); got != tt.want {
+				t.Fatalf("unexpected diff:\ngot:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Ensure sections too large to compare are reported as replaced.
+func TestDiffLines_Large(t *testing.T) {
+	a := make([]string, 3000)
+	b := make([]string, 3000)
+	for i := range a {
+		a[i], b[i] = "a", "b"
+	}
+	ops := diffLines(append([]string{"x"}, a...), append([]string{"x"}, b...))
+	if len(ops) != 6001 || ops[0] != (diffOp{' ', "x"}) || ops[1] != (diffOp{'-', "a"}) || ops[3001] != (diffOp{'+', "b"}) {
+		t.Fatalf("unexpected ops: %d", len(ops))
+	}
+}
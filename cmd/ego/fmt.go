@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/benbjohnson/ego"
+)
+
+// runFmt formats templates in canonical form. Templates are read from stdin
+// if no paths are provided.
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("ego fmt", flag.ContinueOnError)
+	list := fs.Bool("l", false, "list files whose formatting differs")
+	write := fs.Bool("w", false, "write result to source file instead of stdout")
+	diff := fs.Bool("d", false, "display diffs instead of rewriting files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f := &formatter{list: *list, write: *write, diff: *diff}
+
+	if fs.NArg() == 0 {
+		if *write {
+			return fmt.Errorf("cannot use -w with standard input")
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return f.format("<standard input>", src, 0)
+	}
+
+	var failed int
+	if err := walkPaths(fs.Args(), func(path string, explicit bool) error {
+		if filepath.Ext(path) != ".ego" {
+			return nil
+		}
+		if err := f.formatFile(path); err != nil {
+			printError(err)
+			failed++
+		}
+		return nil
+	}); err != nil {
+		return err
+	} else if failed > 0 {
+		return fmt.Errorf("%d file(s) failed", failed)
+	}
+	return nil
+}
+
+// formatter formats templates & reports the result similar to gofmt.
+type formatter struct {
+	list  bool // list files whose formatting differs
+	write bool // write formatted templates to their files
+	diff  bool // print diffs of formatted templates
+}
+
+func (f *formatter) formatFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return f.format(path, src, fi.Mode())
+}
+
+// format formats src. The result is printed to stdout unless a listing,
+// writing, or diff flag is set.
+func (f *formatter) format(path string, src []byte, perm os.FileMode) error {
+	tmpl, err := ego.Parse(bytes.NewReader(src), path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := ego.Fprint(&buf, tmpl); err != nil {
+		return err
+	}
+
+	if !f.list && !f.write && !f.diff {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	} else if bytes.Equal(src, buf.Bytes()) {
+		return nil
+	}
+
+	if f.list {
+		fmt.Println(path)
+	}
+	if f.write {
+		if err := writeFileAtomic(path, buf.Bytes(), perm); err != nil {
+			return err
+		}
+	}
+	if f.diff {
+		fmt.Print(unifiedDiff(path+".orig", path, src, buf.Bytes()))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rpcMessage represents a JSON-RPC 2.0 request, notification, or response.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+}
+
+// isResponse returns true if the message is a response to a request.
+func (msg *rpcMessage) isResponse() bool {
+	return msg.Method == ""
+}
+
+// rpcConn reads & writes JSON-RPC messages framed with a Content-Length
+// header, as used by the language server protocol.
+type rpcConn struct {
+	r *bufio.Reader
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newRPCConn(r io.Reader, w io.Writer) *rpcConn {
+	return &rpcConn{r: bufio.NewReader(r), w: w}
+}
+
+// read reads the next message. Returns io.EOF when the reader is closed.
+func (c *rpcConn) read() (*rpcMessage, error) {
+	// Read headers until a blank line.
+	n := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		if i := strings.Index(line, ":"); i != -1 && strings.EqualFold(line[:i], "Content-Length") {
+			if n, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length header: %q", line)
+			}
+		}
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	// Read & decode body.
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return nil, err
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(buf, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// write writes a message. Safe for concurrent use.
+func (c *rpcConn) write(msg *rpcMessage) error {
+	msg.JSONRPC = "2.0"
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(buf)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(buf)
+	return err
+}
+
+// notify writes a notification with the given method & params.
+func (c *rpcConn) notify(method string, params interface{}) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&rpcMessage{Method: method, Params: buf})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Ensure messages are read from their Content-Length framing.
+func TestRPCConn_Read(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		in     string
+		method string
+		err    string
+	}{
+		{name: "OK", in: "Content-Length: 34\r\n\r\n{\"jsonrpc\":\"2.0\",\"method\":\"exit\"}\n", method: "exit"},
+		{name: "ExtraHeader", in: "content-length: 33\r\nContent-Type: application/vscode-jsonrpc\r\n\r\n{\"jsonrpc\":\"2.0\",\"method\":\"exit\"}", method: "exit"},
+		{name: "EOF", in: "", err: "EOF"},
+		{name: "MissingContentLength", in: "Content-Type: application/vscode-jsonrpc\r\n\r\n{}", err: "missing Content-Length header"},
+		{name: "InvalidContentLength", in: "Content-Length: abc\r\n\r\n{}", err: `invalid Content-Length header: "Content-Length: abc"`},
+		{name: "ShortBody", in: "Content-Length: 40\r\n\r\n{\"jsonrpc\":\"2.0\"}", err: "unexpected EOF"},
+		{name: "UnterminatedHeader", in: "Content-Length: 2", err: "EOF"},
+		{name: "InvalidJSON", in: "Content-Length: 2\r\n\r\n{]", err: "invalid character ']' looking for beginning of object key string"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := newRPCConn(strings.NewReader(tt.in), nil).read()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if msg.Method != tt.method {
+				t.Fatalf("unexpected method: %q", msg.Method)
+			}
+		})
+	}
+}
+
+// Ensure messages are written with a Content-Length header & can be read back.
+func TestRPCConn_Write(t *testing.T) {
+	var buf bytes.Buffer
+	c := newRPCConn(&buf, &buf)
+	if err := c.notify("textDocument/publishDiagnostics", map[string]string{"uri": "file:///é.ego"}); err != nil {
+		t.Fatal(err)
+	} else if err := c.write(&rpcMessage{ID: []byte("1"), Result: []byte("null")}); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "Content-Length: 94\r\n\r\n" + `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///é.ego"}}` +
+		"Content-Length: 38\r\n\r\n" + `{"jsonrpc":"2.0","id":1,"result":null}`
+	if buf.String() != want {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+
+	if msg, err := c.read(); err != nil {
+		t.Fatal(err)
+	} else if msg.isResponse() || string(msg.Params) != `{"uri":"file:///é.ego"}` {
+		t.Fatalf("unexpected message: %#v", msg)
+	}
+	if msg, err := c.read(); err != nil {
+		t.Fatal(err)
+	} else if !msg.isResponse() || string(msg.ID) != "1" {
+		t.Fatalf("unexpected message: %#v", msg)
+	}
+	if _, err := c.read(); err != io.EOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,734 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/benbjohnson/ego"
+)
+
+// runLSP runs a language server for ego templates over stdin & stdout.
+//
+// Requests for templates are proxied to gopls against the Go code generated
+// from the template, which is sent to gopls as an unsaved file. Positions are
+// translated between the two files using the template's source map. All
+// other requests are passed through to gopls unchanged.
+func runLSP(args []string) error {
+	fs := flag.NewFlagSet("ego lsp", flag.ContinueOnError)
+	gopls := fs.String("gopls", "gopls", "path to gopls")
+	var opts ego.Options
+	optionFlags(fs, &opts)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(*gopls)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	} else if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	s := newLSPServer(newRPCConn(os.Stdin, os.Stdout), newRPCConn(stdout, stdin), opts)
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.serveClient() }()
+	go func() {
+		if err := s.serveGopls(); err != nil {
+			errc <- err
+			return
+		}
+		errc <- fmt.Errorf("gopls exited")
+	}()
+	return <-errc
+}
+
+// lspServer proxies messages between the editor and gopls.
+type lspServer struct {
+	client *rpcConn
+	gopls  *rpcConn
+
+	mu      sync.Mutex
+	docs    map[string]*lspDocument // open templates, by URI
+	pending map[string]*lspDocument // template of requests sent to gopls, by id
+	initID  string                  // id of the initialize request
+
+	opts ego.Options // options enabled for every template
+
+	// Reads the packages of components for every template. Replaced when
+	// Go files or templates change so they are read again.
+	resolver *ego.Resolver
+}
+
+func newLSPServer(client, gopls *rpcConn, opts ego.Options) *lspServer {
+	return &lspServer{
+		client:   client,
+		gopls:    gopls,
+		docs:     make(map[string]*lspDocument),
+		pending:  make(map[string]*lspDocument),
+		opts:     opts,
+		resolver: ego.NewResolver(),
+	}
+}
+
+// serveClient handles messages from the editor until it disconnects.
+func (s *lspServer) serveClient() error {
+	for {
+		msg, err := s.client.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.handleClientMessage(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// serveGopls handles messages from gopls until it exits.
+func (s *lspServer) serveGopls() error {
+	for {
+		msg, err := s.gopls.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.handleGoplsMessage(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *lspServer) handleClientMessage(msg *rpcMessage) error {
+	// Responses are replies to requests made by gopls.
+	if msg.isResponse() {
+		return s.gopls.write(msg)
+	}
+
+	// Template documents are synced with gopls as generated Go files.
+	var params struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Range *lspRange `json:"range"`
+			Text  string    `json:"text"`
+		} `json:"contentChanges"`
+	}
+	switch msg.Method {
+	case "workspace/didChangeWatchedFiles", "textDocument/didSave":
+		if err := s.resetResolver(msg); err != nil {
+			return err
+		}
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose":
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		} else if !isTemplateURI(params.TextDocument.URI) {
+			break
+		}
+
+		uri := params.TextDocument.URI
+		switch msg.Method {
+		case "textDocument/didOpen":
+			return s.updateTemplate(uri, func(string) string { return params.TextDocument.Text })
+		case "textDocument/didChange":
+			return s.updateTemplate(uri, func(text string) string {
+				for _, change := range params.ContentChanges {
+					text = applyChange(text, change.Range, change.Text)
+				}
+				return text
+			})
+		default:
+			return s.closeTemplate(uri)
+		}
+	}
+
+	// Translate template positions to the generated files.
+	s.mu.Lock()
+	doc := s.templateOf(msg.Params)
+	translated, ok := s.translateParams(msg.Params, true)
+	if ok && msg.ID != nil {
+		s.pending[string(msg.ID)] = doc
+		if msg.Method == "initialize" {
+			s.initID = string(msg.ID)
+		}
+	}
+	s.mu.Unlock()
+
+	// Requests at positions outside of Go code have no result.
+	if !ok {
+		if msg.ID == nil {
+			return nil
+		}
+		return s.client.write(&rpcMessage{ID: msg.ID, Result: json.RawMessage("null")})
+	}
+
+	msg.Params = translated
+	return s.gopls.write(msg)
+}
+
+func (s *lspServer) handleGoplsMessage(msg *rpcMessage) error {
+	// Translate positions in results of forwarded requests.
+	if msg.isResponse() {
+		s.mu.Lock()
+		id := string(msg.ID)
+		doc, ok := s.pending[id]
+		delete(s.pending, id)
+
+		if ok && len(msg.Result) > 0 {
+			var result interface{}
+			if err := decodeJSON(msg.Result, &result); err != nil {
+				s.mu.Unlock()
+				return err
+			}
+			result, _ = s.translate(result, doc, false)
+
+			// Template changes are always sent in full.
+			if id == s.initID {
+				setFullSync(result)
+			}
+
+			var err error
+			if msg.Result, err = json.Marshal(result); err != nil {
+				s.mu.Unlock()
+				return err
+			}
+		}
+		s.mu.Unlock()
+		return s.client.write(msg)
+	}
+
+	s.mu.Lock()
+	params, _ := s.translateParams(msg.Params, false)
+	msg.Params = params
+
+	// Merge Go diagnostics for generated files with template syntax errors.
+	if msg.Method == "textDocument/publishDiagnostics" {
+		var v struct {
+			URI         string        `json:"uri"`
+			Diagnostics []interface{} `json:"diagnostics"`
+		}
+		if err := decodeJSON(params, &v); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+
+		if doc := s.docs[v.URI]; doc != nil {
+			doc.goDiags = v.Diagnostics
+			params := doc.diagnosticParams()
+			s.mu.Unlock()
+			return s.client.notify(msg.Method, params)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.client.write(msg)
+}
+
+// resetResolver replaces the resolver if a notification reports changed Go
+// files or templates, which may declare components. Open templates are
+// generated again with the new resolver when they next change.
+func (s *lspServer) resetResolver(msg *rpcMessage) error {
+	var params struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Changes []struct {
+			URI string `json:"uri"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return err
+	}
+
+	uris := []string{params.TextDocument.URI}
+	for _, change := range params.Changes {
+		uris = append(uris, change.URI)
+	}
+	for _, uri := range uris {
+		if strings.HasSuffix(uri, ".go") || isTemplateURI(uri) {
+			s.mu.Lock()
+			s.resolver = ego.NewResolver()
+			for _, doc := range s.docs {
+				doc.resolver = s.resolver
+			}
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	return nil
+}
+
+// updateTemplate applies fn to the text of a template, regenerates it and
+// sends the generated file to gopls & the syntax errors to the editor.
+func (s *lspServer) updateTemplate(uri string, fn func(text string) string) error {
+	s.mu.Lock()
+	doc := s.docs[uri]
+	if doc == nil {
+		doc = &lspDocument{uri: uri, path: uriPath(uri), opts: s.opts, resolver: s.resolver}
+		s.docs[uri] = doc
+	}
+	changed := doc.update(fn(doc.text))
+
+	// Sync generated code with gopls.
+	var method string
+	var params interface{}
+	if changed {
+		doc.version++
+		if doc.version == 1 {
+			method = "textDocument/didOpen"
+			params = map[string]interface{}{
+				"textDocument": map[string]interface{}{
+					"uri":        doc.generatedURI(),
+					"languageId": "go",
+					"version":    doc.version,
+					"text":       string(doc.generated),
+				},
+			}
+		} else {
+			method = "textDocument/didChange"
+			params = map[string]interface{}{
+				"textDocument":   map[string]interface{}{"uri": doc.generatedURI(), "version": doc.version},
+				"contentChanges": []interface{}{map[string]interface{}{"text": string(doc.generated)}},
+			}
+		}
+	}
+	diagParams := doc.diagnosticParams()
+	s.mu.Unlock()
+
+	if method != "" {
+		if err := s.gopls.notify(method, params); err != nil {
+			return err
+		}
+	}
+	return s.client.notify("textDocument/publishDiagnostics", diagParams)
+}
+
+// closeTemplate removes a template & closes its generated file in gopls.
+func (s *lspServer) closeTemplate(uri string) error {
+	s.mu.Lock()
+	doc := s.docs[uri]
+	delete(s.docs, uri)
+	s.mu.Unlock()
+
+	if doc == nil {
+		return nil
+	} else if doc.version > 0 {
+		if err := s.gopls.notify("textDocument/didClose", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": doc.generatedURI()},
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Clear diagnostics for the closed template.
+	return s.client.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": []interface{}{},
+	})
+}
+
+// templateOf returns the template referenced by request params, if any.
+func (s *lspServer) templateOf(params json.RawMessage) *lspDocument {
+	var v struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+	}
+	if err := json.Unmarshal(params, &v); err != nil {
+		return nil
+	}
+	return s.docs[v.TextDocument.URI]
+}
+
+// translateParams translates the positions in params. Returns false if
+// a position cannot be translated to the generated file.
+func (s *lspServer) translateParams(params json.RawMessage, toGenerated bool) (json.RawMessage, bool) {
+	if len(params) == 0 {
+		return params, true
+	}
+
+	var v interface{}
+	if err := decodeJSON(params, &v); err != nil {
+		return params, true
+	}
+	v, ok := s.translate(v, nil, toGenerated)
+	if !ok {
+		return nil, false
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return params, true
+	}
+	return buf, true
+}
+
+// translate rewrites the template URIs & positions in v to the generated
+// files or, if toGenerated is false, the generated URIs & positions back to
+// the templates. Positions refer to doc unless a URI is set on the object.
+// Returns false if a position cannot be translated.
+func (s *lspServer) translate(v interface{}, doc *lspDocument, toGenerated bool) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if isPosition(v) {
+			if doc == nil {
+				return v, true
+			}
+			return doc.translatePosition(v, toGenerated)
+		}
+
+		// Objects with a URI contain positions in that document. A URI of
+		// a text document identifier applies to the enclosing object.
+		outer := doc
+		if td, ok := v["textDocument"].(map[string]interface{}); ok {
+			if uri, ok := td["uri"].(string); ok {
+				doc = s.documentOf(uri, toGenerated)
+			}
+		}
+		for _, key := range []string{"uri", "targetUri"} {
+			if uri, ok := v[key].(string); ok {
+				if doc = s.documentOf(uri, toGenerated); doc != nil {
+					v[key] = doc.translateURI(toGenerated)
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		ok := true
+		for _, key := range keys {
+			value, valueDoc := v[key], doc
+			if key == "originSelectionRange" {
+				valueDoc = outer
+			}
+
+			// Workspace edits are keyed by URI.
+			if keyDoc := s.documentOf(key, toGenerated); keyDoc != nil {
+				delete(v, key)
+				key, valueDoc = keyDoc.translateURI(toGenerated), keyDoc
+			}
+
+			var valueOK bool
+			v[key], valueOK = s.translate(value, valueDoc, toGenerated)
+			ok = ok && valueOK
+		}
+		return v, ok
+
+	case []interface{}:
+		ok := true
+		for i := range v {
+			var valueOK bool
+			v[i], valueOK = s.translate(v[i], doc, toGenerated)
+			ok = ok && valueOK
+		}
+		return v, ok
+
+	default:
+		return v, true
+	}
+}
+
+// documentOf returns the open template referenced by uri. This is the
+// template URI if toGenerated is set, otherwise the generated file URI.
+func (s *lspServer) documentOf(uri string, toGenerated bool) *lspDocument {
+	if toGenerated {
+		return s.docs[uri]
+	} else if strings.HasSuffix(uri, ".ego.go") {
+		return s.docs[strings.TrimSuffix(uri, ".go")]
+	}
+	return nil
+}
+
+// lspDocument represents a template open in the editor.
+type lspDocument struct {
+	uri      string
+	path     string
+	opts     ego.Options
+	resolver *ego.Resolver // shared by the templates of the server; may be nil
+	text     string
+	lines    []string
+
+	// Generated Go code from the last template that could be generated.
+	// This is kept while the template has errors so that Go features
+	// continue to work.
+	generated []byte
+	genLines  []string
+	genFile   *token.File
+	sourceMap *ego.SourceMap
+	version   int // version of the generated file sent to gopls
+
+	syntaxDiags []interface{} // template & Go syntax errors
+	goDiags     []interface{} // diagnostics from gopls
+}
+
+// generatedURI returns the URI of the generated Go file.
+func (d *lspDocument) generatedURI() string {
+	return d.uri + ".go"
+}
+
+func (d *lspDocument) translateURI(toGenerated bool) string {
+	if toGenerated {
+		return d.generatedURI()
+	}
+	return d.uri
+}
+
+// update sets the template text and regenerates the Go code. Returns true
+// if the generated code changed.
+func (d *lspDocument) update(text string) bool {
+	d.text, d.lines = text, strings.Split(text, "\n")
+	d.syntaxDiags = nil
+
+	tmpl, err := ego.Parse(strings.NewReader(text), d.path)
+	if errs, ok := err.(ego.ErrorList); ok {
+		for _, e := range errs {
+			d.syntaxDiags = append(d.syntaxDiags, d.diagnostic(e.Pos.LineNo, e.Pos.ColNo, e.Message))
+		}
+		return false
+	} else if err != nil {
+		d.syntaxDiags = append(d.syntaxDiags, d.diagnostic(1, 1, err.Error()))
+		return false
+	}
+	mergeOptions(tmpl, d.opts)
+	tmpl.Resolver = d.resolver
+
+	// Report errors in the Go code at the positions set by //line directives.
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		if errs, ok := err.(scanner.ErrorList); ok {
+			for _, e := range errs {
+				d.syntaxDiags = append(d.syntaxDiags, d.diagnostic(e.Pos.Line, e.Pos.Column, e.Msg))
+			}
+		} else {
+			d.syntaxDiags = append(d.syntaxDiags, d.diagnostic(1, 1, err.Error()))
+		}
+		return false
+	}
+
+	sm, err := tmpl.SourceMap()
+	if err != nil {
+		return false
+	} else if bytes.Equal(buf.Bytes(), d.generated) {
+		d.sourceMap = sm
+		return false
+	}
+
+	// Parse the generated file to read its line directives.
+	fset := token.NewFileSet()
+	if _, err := parser.ParseFile(fset, d.path+".go", buf.Bytes(), parser.ParseComments); err != nil {
+		return false
+	}
+	fset.Iterate(func(f *token.File) bool { d.genFile = f; return false })
+
+	d.generated, d.genLines, d.sourceMap = buf.Bytes(), strings.Split(buf.String(), "\n"), sm
+	return true
+}
+
+// diagnostic returns an error diagnostic at a 1-based line & byte column.
+func (d *lspDocument) diagnostic(line, col int, msg string) interface{} {
+	pos := lspPosition{Line: max(line-1, 0), Character: utf16Col(d.lines, line, col)}
+	return map[string]interface{}{
+		"range":    lspRange{Start: pos, End: pos},
+		"severity": 1,
+		"source":   "ego",
+		"message":  msg,
+	}
+}
+
+// diagnosticParams returns the params to publish all template diagnostics.
+func (d *lspDocument) diagnosticParams() interface{} {
+	diags := make([]interface{}, 0, len(d.syntaxDiags)+len(d.goDiags))
+	diags = append(diags, d.syntaxDiags...)
+	diags = append(diags, d.goDiags...)
+	return map[string]interface{}{"uri": d.uri, "diagnostics": diags}
+}
+
+// translatePosition translates an LSP position between the template and the
+// generated file. Returns false if a template position is not in Go code.
+func (d *lspDocument) translatePosition(v map[string]interface{}, toGenerated bool) (interface{}, bool) {
+	line, _ := v["line"].(json.Number).Int64()
+	char, _ := v["character"].(json.Number).Int64()
+	if d.sourceMap == nil {
+		return v, !toGenerated
+	}
+
+	if toGenerated {
+		pos, ok := d.sourceMap.LookupTemplate(int(line)+1, byteCol(d.lines, int(line)+1, int(char)))
+		if !ok {
+			return v, false
+		}
+		return lspPosition{Line: pos.Line - 1, Character: utf16Col(d.genLines, pos.Line, pos.Column)}, true
+	}
+
+	col := byteCol(d.genLines, int(line)+1, int(char))
+	if pos, ok := d.sourceMap.Lookup(int(line)+1, col); ok {
+		return lspPosition{Line: pos.LineNo - 1, Character: utf16Col(d.lines, pos.LineNo, pos.ColNo)}, true
+	}
+
+	// Fall back to the line directives for code not copied from the template.
+	if int(line) < d.genFile.LineCount() {
+		offset := d.genFile.Offset(d.genFile.LineStart(int(line) + 1))
+		p := d.genFile.PositionFor(d.genFile.Pos(min(offset+col-1, d.genFile.Size())), true)
+		return lspPosition{Line: max(p.Line-1, 0), Character: utf16Col(d.lines, p.Line, p.Column)}, true
+	}
+	return v, true
+}
+
+// lspPosition represents a 0-based line & UTF-16 character offset.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// lspRange represents a range between two positions.
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// isPosition returns true if v is an LSP position object.
+func isPosition(v map[string]interface{}) bool {
+	_, line := v["line"].(json.Number)
+	_, char := v["character"].(json.Number)
+	return len(v) == 2 && line && char
+}
+
+// setFullSync changes the text document sync kind in the result of the
+// initialize request so that the editor sends the full text on change.
+func setFullSync(result interface{}) {
+	m, _ := result.(map[string]interface{})
+	caps, _ := m["capabilities"].(map[string]interface{})
+	if caps == nil {
+		return
+	}
+
+	const full = 1
+	if opts, ok := caps["textDocumentSync"].(map[string]interface{}); ok {
+		opts["change"] = full
+	} else {
+		caps["textDocumentSync"] = full
+	}
+}
+
+// applyChange applies a content change to text. The full text is replaced
+// if rng is nil.
+func applyChange(text string, rng *lspRange, newText string) string {
+	if rng == nil {
+		return newText
+	}
+
+	lines := strings.Split(text, "\n")
+	start, end := textOffset(lines, rng.Start), textOffset(lines, rng.End)
+	if start > end {
+		start, end = end, start
+	}
+	return text[:start] + newText + text[end:]
+}
+
+// textOffset returns the byte offset of pos in lines.
+func textOffset(lines []string, pos lspPosition) int {
+	var offset int
+	for i := 0; i < pos.Line && i < len(lines); i++ {
+		offset += len(lines[i]) + 1
+	}
+	if pos.Line >= len(lines) {
+		return max(offset-1, 0)
+	}
+	return offset + byteCol(lines, pos.Line+1, pos.Character) - 1
+}
+
+// utf16Col converts a 1-based line & byte column to a 0-based UTF-16
+// character offset.
+func utf16Col(lines []string, line, col int) int {
+	if line < 1 || line > len(lines) || col < 1 {
+		return 0
+	}
+
+	s := lines[line-1]
+	if col-1 < len(s) {
+		s = s[:col-1]
+	}
+
+	var n int
+	for _, ch := range s {
+		if ch >= 0x10000 {
+			n += 2 // surrogate pair
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// byteCol converts a 1-based line & 0-based UTF-16 character offset to a
+// 1-based byte column.
+func byteCol(lines []string, line, char int) int {
+	if line < 1 || line > len(lines) {
+		return 1
+	}
+
+	s := lines[line-1]
+	var i, n int
+	for i < len(s) && n < char {
+		ch, size := utf8.DecodeRuneInString(s[i:])
+		if ch >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+		i += size
+	}
+	return i + 1
+}
+
+// isTemplateURI returns true if uri references an ego template.
+func isTemplateURI(uri string) bool {
+	return strings.HasSuffix(uri, ".ego")
+}
+
+// uriPath returns the file path of a file URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// decodeJSON decodes data into v. Numbers are decoded as json.Number so
+// they are written back unchanged.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
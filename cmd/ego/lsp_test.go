@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure byte columns are converted to UTF-16 offsets.
+func TestUTF16Col(t *testing.T) {
+	lines := []string{"a😀é<%= x %>", ""}
+	for _, tt := range []struct {
+		name      string
+		line, col int
+		want      int
+	}{
+		{name: "Start", line: 1, col: 1, want: 0},
+		{name: "ASCII", line: 1, col: 2, want: 1},
+		{name: "SurrogatePair", line: 1, col: 6, want: 3},
+		{name: "TwoByte", line: 1, col: 8, want: 4},
+		{name: "EndOfLine", line: 1, col: 16, want: 12},
+		{name: "PastEndOfLine", line: 1, col: 100, want: 12},
+		{name: "EmptyLine", line: 2, col: 1, want: 0},
+		{name: "LineOutOfRange", line: 3, col: 1, want: 0},
+		{name: "ZeroCol", line: 1, col: 0, want: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := utf16Col(lines, tt.line, tt.col); got != tt.want {
+				t.Fatalf("utf16Col(%d, %d)=%d, want %d", tt.line, tt.col, got, tt.want)
+			}
+		})
+	}
+}
+
+// Ensure UTF-16 offsets are converted to byte columns.
+func TestByteCol(t *testing.T) {
+	lines := []string{"a😀é<%= x %>", ""}
+	for _, tt := range []struct {
+		name       string
+		line, char int
+		want       int
+	}{
+		{name: "Start", line: 1, char: 0, want: 1},
+		{name: "ASCII", line: 1, char: 1, want: 2},
+		{name: "SurrogatePair", line: 1, char: 3, want: 6},
+		{name: "InsideSurrogatePair", line: 1, char: 2, want: 6},
+		{name: "TwoByte", line: 1, char: 4, want: 8},
+		{name: "PastEndOfLine", line: 1, char: 100, want: 16},
+		{name: "EmptyLine", line: 2, char: 5, want: 1},
+		{name: "LineOutOfRange", line: 3, char: 1, want: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := byteCol(lines, tt.line, tt.char); got != tt.want {
+				t.Fatalf("byteCol(%d, %d)=%d, want %d", tt.line, tt.char, got, tt.want)
+			}
+		})
+	}
+}
+
+// Ensure LSP positions are converted to byte offsets in the text.
+func TestTextOffset(t *testing.T) {
+	text := "a😀é\nxé y"
+	lines := strings.Split(text, "\n")
+	for _, tt := range []struct {
+		name string
+		pos  lspPosition
+		want int
+	}{
+		{name: "Start", pos: lspPosition{Line: 0, Character: 0}, want: 0},
+		{name: "AfterSurrogatePair", pos: lspPosition{Line: 0, Character: 3}, want: 5},
+		{name: "EndOfLine", pos: lspPosition{Line: 0, Character: 4}, want: 7},
+		{name: "SecondLine", pos: lspPosition{Line: 1, Character: 2}, want: 11},
+		{name: "PastEndOfLine", pos: lspPosition{Line: 0, Character: 10}, want: 7},
+		{name: "PastLastLine", pos: lspPosition{Line: 5, Character: 0}, want: len(text)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := textOffset(lines, tt.pos); got != tt.want {
+				t.Fatalf("textOffset(%+v)=%d, want %d", tt.pos, got, tt.want)
+			}
+		})
+	}
+}
+
+// Ensure incremental changes are applied at UTF-16 positions.
+func TestApplyChange(t *testing.T) {
+	const text = "a😀é\nxé y"
+	for _, tt := range []struct {
+		name    string
+		rng     *lspRange
+		newText string
+		want    string
+	}{
+		{name: "Full", newText: "b", want: "b"},
+		{name: "Replace", rng: &lspRange{Start: lspPosition{Line: 0, Character: 3}, End: lspPosition{Line: 0, Character: 4}}, newText: "e", want: "a😀e\nxé y"},
+		{name: "Insert", rng: &lspRange{Start: lspPosition{Line: 1, Character: 2}, End: lspPosition{Line: 1, Character: 2}}, newText: "ü", want: "a😀é\nxéü y"},
+		{name: "DeleteSurrogatePair", rng: &lspRange{Start: lspPosition{Line: 0, Character: 1}, End: lspPosition{Line: 0, Character: 3}}, want: "aé\nxé y"},
+		{name: "MultiLine", rng: &lspRange{Start: lspPosition{Line: 0, Character: 3}, End: lspPosition{Line: 1, Character: 2}}, newText: "-", want: "a😀- y"},
+		{name: "Reversed", rng: &lspRange{Start: lspPosition{Line: 1, Character: 0}, End: lspPosition{Line: 0, Character: 4}}, want: "a😀éxé y"},
+		{name: "Append", rng: &lspRange{Start: lspPosition{Line: 2, Character: 0}, End: lspPosition{Line: 2, Character: 0}}, newText: "\n", want: "a😀é\nxé y\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applyChange(text, tt.rng, tt.newText); got != tt.want {
+				t.Fatalf("applyChange()=%q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Ensure positions are translated between a template & its generated code.
+func TestLSPDocument_TranslatePosition(t *testing.T) {
+	d := &lspDocument{uri: "file:///tmp/tmpl.ego", path: "/tmp/tmpl.ego"}
+	if !d.update("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\n<p>é😀<%= nmae %></p>\n<% } %>") {
+		t.Fatalf("template not generated: %v", d.syntaxDiags)
+	}
+
+	// Find the identifier in the generated code.
+	var genPos lspPosition
+	for i, line := range d.genLines {
+		if j := strings.Index(line, "nmae"); j != -1 {
+			genPos = lspPosition{Line: i, Character: utf16Col(d.genLines, i+1, j+1)}
+			break
+		}
+	}
+
+	for _, tt := range []struct {
+		name        string
+		pos         lspPosition
+		toGenerated bool
+		want        lspPosition
+		ok          bool
+	}{
+		{name: "ToGenerated", pos: lspPosition{Line: 2, Character: 10}, toGenerated: true, want: genPos, ok: true},
+		{name: "FromGenerated", pos: genPos, want: lspPosition{Line: 2, Character: 10}, ok: true},
+		{name: "FromGeneratedMiddle", pos: lspPosition{Line: genPos.Line, Character: genPos.Character + 2}, want: lspPosition{Line: 2, Character: 12}, ok: true},
+		{name: "FromGeneratedEnd", pos: lspPosition{Line: genPos.Line, Character: genPos.Character + 4}, want: lspPosition{Line: 2, Character: 14}, ok: true},
+		{name: "Text", pos: lspPosition{Line: 2, Character: 4}, toGenerated: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := map[string]interface{}{
+				"line":      json.Number(strconv.Itoa(tt.pos.Line)),
+				"character": json.Number(strconv.Itoa(tt.pos.Character)),
+			}
+			got, ok := d.translatePosition(v, tt.toGenerated)
+			if ok != tt.ok {
+				t.Fatalf("unexpected ok: %v", ok)
+			} else if !ok {
+				return
+			} else if got != tt.want {
+				t.Fatalf("translatePosition()=%+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Ensure templates are generated with the options of the main command.
+func TestLSPDocument_Update_Options(t *testing.T) {
+	d := &lspDocument{uri: "file:///tmp/tmpl.ego", path: "/tmp/tmpl.ego", opts: ego.Options{StaticText: true}}
+	if !d.update("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\n<p>hi</p>\n<% } %>") {
+		t.Fatalf("template not generated: %v", d.syntaxDiags)
+	} else if !bytes.Contains(d.generated, []byte("w.Write(egoText")) {
+		t.Fatalf("unexpected generated code:\n%s", d.generated)
+	}
+}
+
+// Ensure the resolver shared by templates is replaced when Go files change.
+func TestLSPServer_ResetResolver(t *testing.T) {
+	var client, gopls bytes.Buffer
+	s := newLSPServer(newRPCConn(nil, &client), newRPCConn(nil, &gopls), ego.Options{})
+	if err := s.updateTemplate("file:///tmp/tmpl.ego", func(string) string {
+		return "<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\n<p>hi</p>\n<% } %>"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	doc := s.docs["file:///tmp/tmpl.ego"]
+	if doc.resolver == nil || doc.resolver != s.resolver {
+		t.Fatal("expected shared resolver")
+	}
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		params string
+		reset  bool
+	}{
+		{name: "OtherFile", method: "workspace/didChangeWatchedFiles", params: `{"changes":[{"uri":"file:///tmp/README.md","type":2}]}`},
+		{name: "GoFile", method: "workspace/didChangeWatchedFiles", params: `{"changes":[{"uri":"file:///tmp/README.md","type":2},{"uri":"file:///tmp/button.go","type":2}]}`, reset: true},
+		{name: "Template", method: "workspace/didChangeWatchedFiles", params: `{"changes":[{"uri":"file:///tmp/other.ego","type":1}]}`, reset: true},
+		{name: "SaveGoFile", method: "textDocument/didSave", params: `{"textDocument":{"uri":"file:///tmp/button.go"}}`, reset: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := s.resolver
+			if err := s.handleClientMessage(&rpcMessage{Method: tt.method, Params: json.RawMessage(tt.params)}); err != nil {
+				t.Fatal(err)
+			} else if reset := s.resolver != prev; reset != tt.reset {
+				t.Fatalf("unexpected reset: %v", reset)
+			} else if doc.resolver != s.resolver {
+				t.Fatal("expected document to use the server's resolver")
+			}
+		})
+	}
+}
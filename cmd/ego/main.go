@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"go/scanner"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/benbjohnson/ego"
 )
@@ -17,17 +22,43 @@ var Version string
 
 func main() {
 	if err := run(os.Args[1:]); err != nil {
+		// The go tool has already printed its errors.
+		if err, ok := err.(*exec.ExitError); ok {
+			os.Exit(err.ExitCode())
+		}
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
 func run(args []string) error {
+	// Run subcommand, if specified.
+	if len(args) > 0 {
+		switch args[0] {
+		case "build", "vet":
+			// Wrap go build & go vet to report positions in the templates.
+			return runGoTool(args[0], args[1:])
+		case "fmt":
+			return runFmt(args[1:])
+		case "lsp":
+			return runLSP(args[1:])
+		}
+	}
+
 	fs := flag.NewFlagSet("ego", flag.ContinueOnError)
 	versionFlag := fs.Bool("version", false, "print version")
 	verbose := fs.Bool("v", false, "verbose")
+	check := fs.Bool("check", false, "report stale generated files without writing")
+	diff := fs.Bool("diff", false, "print a diff of stale generated files in check mode")
+	keepBroken := fs.Bool("keep-broken", false, "write output that fails to compile to a .broken file")
+	watch := fs.Bool("watch", false, "regenerate templates as they change")
+	interval := fs.Duration("watch-interval", 500*time.Millisecond, "polling interval in watch mode")
+	var opts ego.Options
+	optionFlags(fs, &opts)
 	if err := fs.Parse(args); err != nil {
 		return err
+	} else if *check && *watch {
+		return fmt.Errorf("-check and -watch cannot be used together")
 	}
 
 	log.SetFlags(0)
@@ -47,82 +78,272 @@ func run(args []string) error {
 		paths = []string{"."}
 	}
 
-	// Find all templates in each directory.
-	for _, path := range paths {
-		fi, err := os.Stat(path)
-		if err != nil {
+	p := &processor{check: *check, diff: *diff, keepBroken: *keepBroken, opts: opts}
+
+	// In watch mode, report errors from the initial run and keep going.
+	if *watch {
+		if err := p.process(paths); err != nil {
 			return err
 		}
+		return p.watch(paths, *interval)
+	}
 
-		// Process all ego files in directory.
-		if fi.IsDir() {
-			if err := processDir(path); err != nil {
-				return err
-			}
-			continue
-		}
+	if err := p.process(paths); err != nil {
+		return err
+	} else if p.failed > 0 {
+		return fmt.Errorf("%d file(s) failed", p.failed)
+	}
 
-		// Ignore files without an .ego extension.
-		if filepath.Ext(path) != ".ego" {
-			continue
+	// Report generated files which do not match their templates.
+	if len(p.stale) > 0 {
+		for _, msg := range p.stale {
+			fmt.Println(msg)
 		}
+		return fmt.Errorf("%d generated file(s) out of date", len(p.stale))
+	}
 
-		// Process individual file.
-		if err := processFile(path); err != nil {
-			return err
+	return nil
+}
+
+// printError prints each error in err on a separate line.
+func printError(err error) {
+	switch err := err.(type) {
+	case ego.ErrorList:
+		for _, e := range err {
+			fmt.Fprintln(os.Stderr, e)
+		}
+	case scanner.ErrorList:
+		for _, e := range err {
+			fmt.Fprintln(os.Stderr, e)
 		}
+	default:
+		fmt.Fprintln(os.Stderr, err)
 	}
+}
 
-	return nil
+// processor generates Go files from ego templates.
+type processor struct {
+	// If set, generated files are compared against existing files
+	// instead of being written. Differences are reported in stale.
+	check bool
+	diff  bool // print unified diff of stale files
+
+	// If set, generated code which cannot be formatted is written to a
+	// file with a ".broken" extension next to the destination file.
+	keepBroken bool
+
+	// Options enabled for every template.
+	opts ego.Options
+
+	// Reads the packages of components. Replaced on each pass so changed
+	// files are read again in watch mode.
+	resolver *ego.Resolver
+
+	// Directories of processed templates. The static text file of each
+	// directory is updated after its templates are processed.
+	dirs map[string]bool
+
+	// Static text of each generated template by path. Kept across passes in
+	// watch mode since unchanged templates are not generated again.
+	texts map[string]staticText
+
+	stale  []string
+	failed int // number of templates which could not be processed
 }
 
-func processDir(path string) error {
-	fis, err := ioutil.ReadDir(path)
-	if err != nil {
+// process processes all ego files in each path. Errors from individual
+// files are printed and counted so that every file is processed.
+func (p *processor) process(paths []string) error {
+	p.resolver = ego.NewResolver()
+	if err := walkPaths(paths, func(path string, explicit bool) error {
+		// Report generated files whose template no longer exists.
+		if p.check && !explicit && strings.HasSuffix(path, ".ego.go") {
+			if _, err := os.Stat(strings.TrimSuffix(path, ".go")); os.IsNotExist(err) {
+				p.stale = append(p.stale, fmt.Sprintf("%s: orphaned", path))
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if err := p.processFile(path); err != nil {
+			printError(err)
+			p.failed++
+		}
+		return nil
+	}); err != nil {
 		return err
 	}
-	for _, fi := range fis {
-		if err := processFile(filepath.Join(path, fi.Name())); err != nil {
+
+	p.processStaticText()
+	return nil
+}
+
+// walkPaths calls fn for each file in paths. Directories are walked
+// recursively and fn is called for each file that is not in a skipped
+// directory. Files listed in paths are passed to fn with explicit set.
+func walkPaths(paths []string, fn func(path string, explicit bool) error) error {
+	for _, path := range paths {
+		// Strip Go-style "/..." suffix. Directories are always processed recursively.
+		if path == "..." {
+			path = "."
+		} else if strings.HasSuffix(path, "/...") {
+			path = strings.TrimSuffix(path, "/...")
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		} else if !fi.IsDir() {
+			if err := fn(path, true); err != nil {
+				return err
+			}
+			continue
+		}
+
+		root := path
+		if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			} else if d.IsDir() {
+				if path != root && skipDir(d.Name()) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return fn(path, false)
+		}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func processFile(path string) error {
+// skipDir returns true if a directory should not be traversed. This matches
+// the directories ignored by the go tool: vendor, testdata, and directories
+// beginning with "." or "_".
+func skipDir(name string) bool {
+	return name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+func (p *processor) processFile(path string) error {
 	if filepath.Ext(path) != ".ego" {
 		return nil
 	}
 
 	log.Printf("[process] %s", path)
+	p.addDir(filepath.Dir(path))
 
 	fi, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
 
-	// Read current file, if it exists.
+	// Parse file & write to buffer.
 	dest := path + ".go"
-	existing, err := ioutil.ReadFile(dest)
-	if err != nil && !os.IsNotExist(err) {
+	tmpl, err := ego.ParseFile(path)
+	if err != nil {
 		return err
 	}
+	mergeOptions(tmpl, p.opts)
+	tmpl.Resolver = p.resolver
 
-	// Parse file & write to buffer. Ignore if equal to contents.
 	var buf bytes.Buffer
-	if tmpl, err := ego.ParseFile(path); err != nil {
+	_, texts, err := tmpl.WriteToWithStaticText(&buf)
+	p.setStaticText(path, tmpl, buf.Bytes(), texts, err)
+	if err != nil {
+		// Leave the previously generated file in place so the package
+		// still builds. Optionally write the output for debugging.
+		if p.keepBroken && !p.check {
+			if err := ioutil.WriteFile(dest+".broken", buf.Bytes(), fi.Mode()); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	if err := p.writeGenerated(dest, buf.Bytes(), fi.Mode()); err != nil {
 		return err
-	} else if _, err := tmpl.WriteTo(&buf); err != nil {
-		ioutil.WriteFile(dest, buf.Bytes(), fi.Mode())
+	}
+	return p.removeBroken(dest)
+}
+
+// writeGenerated writes data to the generated file dest unless it is
+// unchanged. In check mode, a missing or stale file is reported instead.
+func (p *processor) writeGenerated(dest string, data []byte, perm os.FileMode) error {
+	// Read current file, if it exists. Ignore if equal to contents.
+	existing, err := ioutil.ReadFile(dest)
+	if err != nil && !os.IsNotExist(err) {
 		return err
-	} else if bytes.Equal(existing, buf.Bytes()) {
+	}
+	missing := os.IsNotExist(err)
+	if !missing && bytes.Equal(existing, data) {
+		return nil
+	}
+
+	// In check mode, report the stale file instead of writing.
+	if p.check {
+		if missing {
+			p.stale = append(p.stale, fmt.Sprintf("%s: missing", dest))
+			return nil
+		}
+
+		msg := fmt.Sprintf("%s: stale", dest)
+		if p.diff {
+			msg += "\n" + strings.TrimSuffix(unifiedDiff(dest, dest+" (generated)", existing, data), "\n")
+		}
+		p.stale = append(p.stale, msg)
 		return nil
 	}
 
 	// Write to file.
-	if err := ioutil.WriteFile(dest, buf.Bytes(), fi.Mode()); err != nil {
+	return writeFileAtomic(dest, data, perm)
+}
+
+// optionFlags defines the flags which enable template options on fs.
+func optionFlags(fs *flag.FlagSet, opts *ego.Options) {
+	fs.BoolVar(&opts.ReturnErrors, "errors", false, "return write errors from generated code")
+	fs.BoolVar(&opts.CheckContext, "context", false, "stop rendering when the context is done")
+	fs.BoolVar(&opts.StaticText, "static", false, "write text from package-level byte slices")
+	fs.BoolVar(&opts.TypedAttrs, "typed-attrs", false, "pass component attributes as map[string]interface{}")
+	fs.BoolVar(&opts.Construct, "construct", false, "create components with New constructors or by pointer")
+}
+
+// mergeOptions enables the options set in opts on a template in addition to
+// the options set by the template's pragmas.
+func mergeOptions(tmpl *ego.Template, opts ego.Options) {
+	tmpl.Options.ReturnErrors = tmpl.Options.ReturnErrors || opts.ReturnErrors
+	tmpl.Options.CheckContext = tmpl.Options.CheckContext || opts.CheckContext
+	tmpl.Options.StaticText = tmpl.Options.StaticText || opts.StaticText
+	tmpl.Options.TypedAttrs = tmpl.Options.TypedAttrs || opts.TypedAttrs
+	tmpl.Options.Construct = tmpl.Options.Construct || opts.Construct
+}
+
+// removeBroken removes the broken output from a previous run, if it exists.
+func (p *processor) removeBroken(dest string) error {
+	if p.check {
+		return nil
+	} else if err := os.Remove(dest + ".broken"); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-
 	return nil
 }
+
+// writeFileAtomic writes data to a temporary file and renames it to filename
+// so the destination file is never left partially written.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	} else if err := f.Chmod(perm); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+const testTemplate = "<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\n<p>hi</p>\n<% } %>\n"
+
+// writeTestFiles writes files by their path relative to dir.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// generateTestTemplate writes the generated code for the template at path.
+func generateTestTemplate(t *testing.T, path string) {
+	t.Helper()
+	tmpl, err := ego.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(path+".go", buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure check mode reports missing, stale, & orphaned generated files
+// without writing them.
+func TestProcessor_Process_Check(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.ego":    testTemplate,
+		"b.ego":    testTemplate,
+		"b.ego.go": "package foo\n",
+		"c.ego.go": "package foo\n",
+		"d.ego":    testTemplate,
+	})
+	generateTestTemplate(t, filepath.Join(dir, "d.ego"))
+
+	p := &processor{check: true}
+	if err := p.process([]string{dir}); err != nil {
+		t.Fatal(err)
+	} else if p.failed != 0 {
+		t.Fatalf("unexpected failures: %d", p.failed)
+	} else if want := []string{
+		filepath.Join(dir, "a.ego.go") + ": missing",
+		filepath.Join(dir, "b.ego.go") + ": stale",
+		filepath.Join(dir, "c.ego.go") + ": orphaned",
+	}; !reflect.DeepEqual(p.stale, want) {
+		t.Fatalf("unexpected stale files: %q", p.stale)
+	}
+
+	// Ensure no files were written.
+	if _, err := os.Stat(filepath.Join(dir, "a.ego.go")); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if buf, err := ioutil.ReadFile(filepath.Join(dir, "b.ego.go")); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "package foo\n" {
+		t.Fatalf("stale file overwritten: %q", buf)
+	}
+
+	// Ensure the diff is reported with the stale file.
+	p = &processor{check: true, diff: true}
+	if err := p.process([]string{filepath.Join(dir, "b.ego")}); err != nil {
+		t.Fatal(err)
+	} else if len(p.stale) != 1 || !strings.HasPrefix(p.stale[0], filepath.Join(dir, "b.ego.go")+": stale\n--- ") || !strings.Contains(p.stale[0], "\n+func Render(") {
+		t.Fatalf("unexpected stale files: %q", p.stale)
+	}
+
+	// Ensure the command fails until the files are generated.
+	if err := run([]string{"-check", dir}); err == nil || err.Error() != "3 generated file(s) out of date" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := os.Remove(filepath.Join(dir, "c.ego.go")); err != nil {
+		t.Fatal(err)
+	} else if err := run([]string{dir}); err != nil {
+		t.Fatal(err)
+	} else if err := run([]string{"-check", dir}); err != nil {
+		t.Fatalf("unexpected error after generating: %v", err)
+	}
+}
+
+// Ensure paths are walked recursively, skipping the directories ignored by
+// the go tool unless files in them are listed explicitly.
+func TestWalkPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.ego":              "",
+		"sub/b.ego":          "",
+		"sub/deep/c.ego":     "",
+		"sub/vendor/d.ego":   "",
+		"vendor/e.ego":       "",
+		"testdata/f.ego":     "",
+		".git/g.ego":         "",
+		"_old/h.ego":         "",
+		"sub/.hidden/i.ego":  "",
+		"sub/_tmp/j.ego":     "",
+		"sub/testdata/k.ego": "",
+	})
+
+	// Walk paths relative to the temporary directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	all := []string{"a.ego", "sub/b.ego", "sub/deep/c.ego"}
+	for _, tt := range []struct {
+		name  string
+		paths []string
+		want  []string // "*" suffix if explicit
+	}{
+		{name: "Dir", paths: []string{"."}, want: all},
+		{name: "Ellipsis", paths: []string{"..."}, want: all},
+		{name: "DirEllipsis", paths: []string{"./..."}, want: all},
+		{name: "Subdir", paths: []string{"sub/..."}, want: []string{"sub/b.ego", "sub/deep/c.ego"}},
+		{name: "SkippedRoot", paths: []string{"vendor"}, want: []string{"vendor/e.ego"}},
+		{name: "Files", paths: []string{"vendor/e.ego", "sub/deep/c.ego"}, want: []string{"vendor/e.ego*", "sub/deep/c.ego*"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			if err := walkPaths(tt.paths, func(path string, explicit bool) error {
+				if path = filepath.ToSlash(path); explicit {
+					path += "*"
+				}
+				got = append(got, path)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected paths: %q", got)
+			}
+		})
+	}
+
+	// Ensure missing paths are reported.
+	if err := walkPaths([]string{"missing"}, func(string, bool) error { return nil }); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure files are replaced atomically & temporary files are removed.
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.ego.go")
+	if err := writeFileAtomic(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := writeFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "new" {
+		t.Fatalf("unexpected data: %q", buf)
+	} else if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0644 {
+		t.Fatalf("unexpected mode: %s", fi.Mode())
+	}
+
+	// Ensure a failed rename leaves no temporary file behind.
+	if err := os.Mkdir(filepath.Join(dir, "b.ego.go"), 0777); err != nil {
+		t.Fatal(err)
+	} else if err := writeFileAtomic(filepath.Join(dir, "b.ego.go"), []byte("data"), 0644); err == nil {
+		t.Fatal("expected error")
+	}
+	if fis, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(fis) != 2 {
+		t.Fatalf("unexpected files: %d", len(fis))
+	}
+}
+
+// Ensure a template which fails to generate leaves its previous output in
+// place & only writes the broken output if requested.
+func TestProcessor_ProcessFile_Broken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.ego")
+	writeTestFiles(t, dir, map[string]string{"a.ego": testTemplate})
+	generateTestTemplate(t, path)
+	prev, err := ioutil.ReadFile(path + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the template so the generated code cannot be formatted.
+	broken := strings.Replace(testTemplate, "<p>hi</p>", "<p><%= x + %></p>", 1)
+	writeTestFiles(t, dir, map[string]string{"a.ego": broken})
+
+	assertFile := func(t *testing.T, path string, want []byte) {
+		t.Helper()
+		if buf, err := ioutil.ReadFile(path); want == nil && !os.IsNotExist(err) {
+			t.Fatalf("expected %s to not exist: %v", path, err)
+		} else if want != nil && err != nil {
+			t.Fatal(err)
+		} else if want != nil && !bytes.Equal(buf, want) {
+			t.Fatalf("unexpected %s:\n%s", path, buf)
+		}
+	}
+
+	t.Run("Broken", func(t *testing.T) {
+		p := &processor{}
+		if err := p.processFile(path); err == nil {
+			t.Fatal("expected error")
+		}
+		assertFile(t, path+".go", prev)
+		assertFile(t, path+".go.broken", nil)
+	})
+
+	t.Run("KeepBroken", func(t *testing.T) {
+		p := &processor{keepBroken: true}
+		if err := p.processFile(path); err == nil {
+			t.Fatal("expected error")
+		}
+		assertFile(t, path+".go", prev)
+		if buf, err := ioutil.ReadFile(path + ".go.broken"); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(string(buf), "x + )") {
+			t.Fatalf("unexpected broken output:\n%s", buf)
+		}
+	})
+
+	t.Run("Fixed", func(t *testing.T) {
+		writeTestFiles(t, dir, map[string]string{"a.ego": strings.Replace(testTemplate, "hi", "bye", 1)})
+		p := &processor{keepBroken: true}
+		if err := p.processFile(path); err != nil {
+			t.Fatal(err)
+		}
+		assertFile(t, path+".go.broken", nil)
+		if buf, err := ioutil.ReadFile(path + ".go"); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(string(buf), "bye") {
+			t.Fatalf("unexpected output:\n%s", buf)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/benbjohnson/ego"
+)
+
+// staticTextFile is the name of the file in each directory which declares
+// the text used by templates generated with the StaticText option.
+const staticTextFile = "ego_static.go"
+
+// staticTextPrefix is the prefix of the names of the declared text.
+const staticTextPrefix = "egoText"
+
+// staticText holds the static text of a generated template.
+type staticText struct {
+	pkg   string
+	texts []string // blank if the template does not use the StaticText option
+}
+
+// setStaticText records the static text of a template generated by
+// processFile so it does not need to be generated again. The text of a
+// template which failed to generate is forgotten.
+func (p *processor) setStaticText(path string, tmpl *ego.Template, code []byte, texts []string, err error) {
+	if p.texts == nil {
+		p.texts = make(map[string]staticText)
+	}
+	if err != nil {
+		delete(p.texts, path)
+		return
+	} else if !tmpl.Options.StaticText {
+		p.texts[path] = staticText{}
+		return
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), path, code, parser.PackageClauseOnly)
+	if err != nil {
+		delete(p.texts, path)
+		return
+	}
+	p.texts[path] = staticText{pkg: f.Name.Name, texts: texts}
+}
+
+// addDir records the directory of a processed template.
+func (p *processor) addDir(dir string) {
+	if p.dirs == nil {
+		p.dirs = make(map[string]bool)
+	}
+	p.dirs[dir] = true
+}
+
+// processStaticText updates the static text file of each directory with
+// processed templates. Errors are printed and counted.
+func (p *processor) processStaticText() {
+	dirs := make([]string, 0, len(p.dirs))
+	for dir := range p.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	p.dirs = nil
+
+	for _, dir := range dirs {
+		if err := p.processStaticTextFile(dir); err != nil {
+			printError(err)
+			p.failed++
+		}
+	}
+}
+
+// processStaticTextFile writes the static text file for the templates in dir.
+// The text of every template in the directory is included, not only the
+// processed templates, since the text is shared. Text is reused from
+// processFile & templates are only generated here if they were not
+// processed. The file is removed once no template uses the StaticText option.
+//
+// Templates with errors keep their previously generated file so the text it
+// uses is kept from the existing static text file.
+func (p *processor) processStaticTextFile(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.ego"))
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, staticTextFile)
+	var existing map[string]string
+	var pkg string
+	var texts []string
+	for _, path := range paths {
+		name, a, err := p.staticText(path)
+		if err != nil {
+			// Errors in the template have already been reported.
+			if existing == nil {
+				if existing, err = readStaticTextFile(dest); err != nil {
+					return err
+				}
+			}
+			if name, a, err = previousStaticText(path+".go", existing); err != nil {
+				return err
+			} else if len(a) > 0 {
+				fmt.Fprintf(os.Stderr, "%s: keeping text used by %s.go since the template has errors\n", dest, path)
+			}
+		}
+		if len(a) == 0 {
+			continue
+		} else if pkg != "" && pkg != name {
+			return fmt.Errorf("%s: found packages %s and %s", dir, pkg, name)
+		}
+		pkg = name
+		texts = append(texts, a...)
+	}
+
+	if pkg == "" {
+		return p.removeStaticTextFile(dest)
+	}
+
+	// Never overwrite a file of the same name written by the user.
+	if b, err := ioutil.ReadFile(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err == nil && !isGenerated(b) {
+		return fmt.Errorf("%s: cannot overwrite file not generated by ego", dest)
+	}
+
+	var buf bytes.Buffer
+	if err := ego.WriteStaticText(&buf, pkg, texts); err != nil {
+		return err
+	}
+	return p.writeGenerated(dest, buf.Bytes(), 0666)
+}
+
+// staticText returns the package name & static text of a template. Returns
+// no text if the template does not use the StaticText option. The template
+// is generated if it was not generated by processFile.
+func (p *processor) staticText(path string) (pkg string, texts []string, err error) {
+	if st, ok := p.texts[path]; ok {
+		return st.pkg, st.texts, nil
+	}
+
+	tmpl, err := ego.ParseFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	mergeOptions(tmpl, p.opts)
+	if !tmpl.Options.StaticText {
+		return "", nil, nil
+	}
+	tmpl.Resolver = p.resolver
+
+	var buf bytes.Buffer
+	_, texts, err = tmpl.WriteToWithStaticText(&buf)
+	p.setStaticText(path, tmpl, buf.Bytes(), texts, err)
+	if err != nil {
+		return "", nil, err
+	}
+	st := p.texts[path]
+	return st.pkg, st.texts, nil
+}
+
+// isGenerated returns true if a file starts with the header written by ego.
+func isGenerated(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("// Generated by ego."))
+}
+
+// previousStaticText returns the package name & the text used by a
+// previously generated file. The text is found by name in existing, the
+// declarations of the current static text file.
+func previousStaticText(filename string, existing map[string]string) (pkg string, texts []string, err error) {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+	if os.IsNotExist(err) {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	ast.Inspect(f, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if !ok || !strings.HasPrefix(ident.Name, staticTextPrefix) {
+			return true
+		}
+		if text, ok := existing[ident.Name]; ok {
+			texts = append(texts, text)
+		} else if err == nil {
+			err = fmt.Errorf("%s: cannot find previous text %s", filename, ident.Name)
+		}
+		return true
+	})
+	return f.Name.Name, texts, err
+}
+
+// readStaticTextFile returns the text declared by a static text file by name.
+func readStaticTextFile(filename string) (map[string]string, error) {
+	m := make(map[string]string)
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.ValueSpec)
+			if len(spec.Names) != 1 || len(spec.Values) != 1 {
+				continue
+			}
+			call, ok := spec.Values[0].(*ast.CallExpr)
+			if !ok || len(call.Args) != 1 {
+				continue
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				continue
+			}
+			text, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return nil, err
+			}
+			m[spec.Names[0].Name] = text
+		}
+	}
+	return m, nil
+}
+
+// removeStaticTextFile removes a static text file that is no longer used.
+// In check mode, the file is reported as orphaned instead.
+func (p *processor) removeStaticTextFile(dest string) error {
+	b, err := ioutil.ReadFile(dest)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if !isGenerated(b) {
+		return nil // not written by ego
+	}
+
+	if p.check {
+		p.stale = append(p.stale, fmt.Sprintf("%s: orphaned", dest))
+		return nil
+	}
+	return os.Remove(dest)
+}
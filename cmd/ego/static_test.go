@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Ensure the static text file is written from the text of the processed
+// templates & is not written over a file not generated by ego.
+func TestProcessor_ProcessStaticText(t *testing.T) {
+	const staticTemplate = "<%# ego:static %>\n" + testTemplate
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.ego": staticTemplate,
+		"b.ego": strings.Replace(staticTemplate, "hi", "bye", 1),
+		"c.ego": testTemplate,
+	})
+
+	p := &processor{}
+	if err := p.process([]string{dir}); err != nil {
+		t.Fatal(err)
+	} else if p.failed != 0 {
+		t.Fatalf("unexpected failures: %d", p.failed)
+	} else if len(p.texts) != 3 {
+		t.Fatalf("unexpected text: %v", p.texts)
+	} else if st := p.texts[filepath.Join(dir, "a.ego")]; st.pkg != "foo" || len(st.texts) == 0 {
+		t.Fatalf("unexpected text: %+v", st)
+	} else if st := p.texts[filepath.Join(dir, "c.ego")]; st.pkg != "" || len(st.texts) != 0 {
+		t.Fatalf("unexpected text: %+v", st)
+	}
+
+	dest := filepath.Join(dir, staticTextFile)
+	if buf, err := ioutil.ReadFile(dest); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(buf), `[]byte("\n<p>hi</p>\n")`) || !strings.Contains(string(buf), `[]byte("\n<p>bye</p>\n")`) {
+		t.Fatalf("unexpected static text file:\n%s", buf)
+	}
+
+	// Ensure a file written by the user is left alone.
+	writeTestFiles(t, dir, map[string]string{staticTextFile: "package foo\n"})
+	p = &processor{}
+	if err := p.process([]string{filepath.Join(dir, "a.ego")}); err != nil {
+		t.Fatal(err)
+	} else if p.failed != 1 {
+		t.Fatalf("unexpected failures: %d", p.failed)
+	} else if buf, err := ioutil.ReadFile(dest); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "package foo\n" {
+		t.Fatalf("file overwritten:\n%s", buf)
+	}
+}
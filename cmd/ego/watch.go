@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/benbjohnson/ego"
+)
+
+// watchDebounce is the time the templates must be unchanged before they are
+// regenerated. This coalesces the bursts of events produced by editors.
+const watchDebounce = 100 * time.Millisecond
+
+// fileState represents the attributes used to detect a file change.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileState) equal(other fileState) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+// watch polls the templates in paths and regenerates each template that
+// changes. Errors are printed and do not stop the watch. Never returns
+// unless the initial scan of paths fails.
+func (p *processor) watch(paths []string, interval time.Duration) error {
+	w := &templateWatcher{
+		interval: interval,
+		snapshot: func() (map[string]fileState, error) { return templateStates(paths) },
+		sleep:    time.Sleep,
+	}
+	if err := w.init(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "watching %d template(s)\n", len(w.prev))
+	for {
+		changed := w.wait()
+
+		// Regenerate each changed template.
+		p.resolver = ego.NewResolver()
+		for _, path := range changed {
+			if err := p.processFile(path); err != nil {
+				printError(err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "regenerated %s\n", path)
+		}
+		p.processStaticText()
+	}
+}
+
+// templateWatcher detects changed templates by polling their states.
+type templateWatcher struct {
+	interval time.Duration
+	snapshot func() (map[string]fileState, error) // returns template states
+	sleep    func(time.Duration)
+
+	prev map[string]fileState // states when last changed
+}
+
+// init reads the initial states of the templates.
+func (w *templateWatcher) init() (err error) {
+	w.prev, err = w.snapshot()
+	return err
+}
+
+// wait blocks until templates are added or modified & then stop changing.
+// Returns the changed templates. Errors are printed and polling continues.
+func (w *templateWatcher) wait() []string {
+	for {
+		w.sleep(w.interval)
+
+		curr, err := w.snapshot()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		} else if len(changedTemplates(w.prev, curr)) == 0 {
+			w.prev = curr
+			continue
+		}
+
+		// Wait for the templates to stop changing.
+		for {
+			w.sleep(watchDebounce)
+			next, err := w.snapshot()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				break
+			} else if len(changedTemplates(curr, next)) == 0 {
+				break
+			}
+			curr = next
+		}
+
+		changed := changedTemplates(w.prev, curr)
+		w.prev = curr
+		if len(changed) > 0 {
+			return changed
+		}
+	}
+}
+
+// templateStates returns the current state of every template in paths.
+func templateStates(paths []string) (map[string]fileState, error) {
+	m := make(map[string]fileState)
+	if err := walkPaths(paths, func(path string, explicit bool) error {
+		if filepath.Ext(path) != ".ego" {
+			return nil
+		}
+
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return nil // removed during walk
+		} else if err != nil {
+			return err
+		}
+		m[path] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// changedTemplates returns a sorted list of templates that were added or
+// modified between prev and curr. Removed templates are ignored.
+func changedTemplates(prev, curr map[string]fileState) []string {
+	var a []string
+	for path, state := range curr {
+		if prevState, ok := prev[path]; !ok || !prevState.equal(state) {
+			a = append(a, path)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Ensure added & modified templates are reported once they stop changing.
+func TestTemplateWatcher_Wait(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	a := fileState{modTime: t0, size: 10}
+	b := fileState{modTime: t0, size: 20}
+	modified := func(s fileState, d time.Duration) fileState {
+		return fileState{modTime: s.modTime.Add(d), size: s.size}
+	}
+
+	// Snapshots returned by each poll in order.
+	snapshots := []map[string]fileState{
+		{"a.ego": a, "b.ego": b},              // initial
+		{"a.ego": a, "b.ego": b},              // unchanged
+		{"a.ego": a},                          // removed
+		{"a.ego": modified(a, time.Second)},   // modified
+		{"a.ego": modified(a, 2*time.Second)}, // still changing
+		{"a.ego": modified(a, 2*time.Second)}, // stable
+		nil,                                   // error
+		{"a.ego": modified(a, 2*time.Second), "c.ego": a}, // added
+		{"a.ego": modified(a, 2*time.Second), "c.ego": a}, // stable
+	}
+
+	var sleeps []time.Duration
+	w := &templateWatcher{
+		interval: time.Second,
+		snapshot: func() (map[string]fileState, error) {
+			if len(snapshots) == 0 {
+				t.Fatal("unexpected snapshot")
+			}
+			m := snapshots[0]
+			snapshots = snapshots[1:]
+			if m == nil {
+				return nil, errors.New("test: snapshot failed")
+			}
+			return m, nil
+		},
+		sleep: func(d time.Duration) { sleeps = append(sleeps, d) },
+	}
+	if err := w.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if changed := w.wait(); !reflect.DeepEqual(changed, []string{"a.ego"}) {
+		t.Fatalf("unexpected changes: %q", changed)
+	} else if want := []time.Duration{time.Second, time.Second, time.Second, watchDebounce, watchDebounce}; !reflect.DeepEqual(sleeps, want) {
+		t.Fatalf("unexpected sleeps: %v", sleeps)
+	}
+
+	sleeps = nil
+	if changed := w.wait(); !reflect.DeepEqual(changed, []string{"c.ego"}) {
+		t.Fatalf("unexpected changes: %q", changed)
+	} else if want := []time.Duration{time.Second, time.Second, watchDebounce}; !reflect.DeepEqual(sleeps, want) {
+		t.Fatalf("unexpected sleeps: %v", sleeps)
+	}
+}
+
+// Ensure changes are found by comparing modification times & sizes.
+func TestChangedTemplates(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	prev := map[string]fileState{
+		"a.ego": {modTime: t0, size: 1},
+		"b.ego": {modTime: t0, size: 1},
+		"c.ego": {modTime: t0, size: 1},
+		"d.ego": {modTime: t0, size: 1},
+	}
+	curr := map[string]fileState{
+		"a.ego": {modTime: t0, size: 1},
+		"b.ego": {modTime: t0.Add(time.Millisecond), size: 1},
+		"c.ego": {modTime: t0, size: 2},
+		"e.ego": {modTime: t0, size: 1},
+	}
+	if got, want := changedTemplates(prev, curr), []string{"b.ego", "c.ego", "e.ego"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("changedTemplates()=%q, want %q", got, want)
+	}
+}
+
+// Ensure the states of templates are read from the file system.
+func TestTemplateStates(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.ego":        "abc",
+		"a.ego.go":     "package foo\n",
+		"sub/b.ego":    "",
+		"vendor/c.ego": "",
+	})
+
+	m, err := templateStates([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(m) != 2 {
+		t.Fatalf("unexpected states: %v", m)
+	} else if s, ok := m[filepath.Join(dir, "a.ego")]; !ok || s.size != 3 || s.modTime.IsZero() {
+		t.Fatalf("unexpected state: %+v", s)
+	} else if _, ok := m[filepath.Join(dir, "sub", "b.ego")]; !ok {
+		t.Fatal("expected sub/b.ego state")
+	}
+}
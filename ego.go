@@ -10,103 +10,747 @@ import (
 	"io"
 	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // Template represents an entire Ego template.
 // A template consists of zero or more blocks.
-// Blocks can be either a TextBlock, a PrintBlock, a RawPrintBlock, or a CodeBlock.
+// Blocks can be either a TextBlock, a PrintBlock, a RawPrintBlock, a CodeBlock, or a CommentBlock.
 type Template struct {
-	Path   string
-	Blocks []Block
+	Path    string
+	Blocks  []Block
+	Options Options
+
+	// Reads the packages of components. Templates which share a Resolver
+	// only read each package once. If nil, packages are read each time the
+	// template is generated.
+	Resolver *Resolver
+}
+
+// Options represents settings that change the generated code. Options can be
+// set in a template with a pragma comment, such as "<%# ego:errors %>".
+type Options struct {
+	// If set, write errors are returned instead of discarded. Rendering
+	// stops at the first failed write. The render function must return an
+	// error, as must the Render method & closures of components.
+	//
+	// Set by the "ego:errors" pragma.
+	ReturnErrors bool
+
+	// If set, rendering stops with the context's error once ctx is done.
+	// The context is checked at the start of each loop iteration & before
+	// each component is rendered. If ReturnErrors is not set, the error is
+	// raised as a panic which is recovered by Recover.
+	//
+	// Set by the "ego:context" pragma.
+	CheckContext bool
+
+	// If set, text is written with w.Write from package-level byte slices
+	// instead of converting a string on every write. The byte slices must
+	// be declared in a separate file of the package which is written by
+	// WriteStaticText so text shared by templates is only declared once.
+	//
+	// Set by the "ego:static" pragma.
+	StaticText bool
+
+	// If set, the Attrs field of a component is set to a
+	// map[string]interface{} holding the original values of the attributes
+	// instead of an Attrs. Use FormatAttrs to write them as HTML.
+	//
+	// Set by the "ego:typedattrs" pragma.
+	TypedAttrs bool
+
+	// If set, components are created by their New<T> constructor, if one
+	// exists, & are declared as pointers if their Render method has a
+	// pointer receiver. This requires reading the component's package. An
+	// error is returned if the package or the type cannot be found.
+	//
+	// Set by the "ego:construct" pragma.
+	Construct bool
 }
 
 // WriteTo writes the template to a writer.
 func (t *Template) WriteTo(w io.Writer) (n int64, err error) {
+	src, out, _, err := t.generate()
+	if err != nil {
+		n, _ = src.WriteTo(w)
+		return n, err
+	}
+	return out.WriteTo(w)
+}
+
+// SourceMap returns a map from positions in the generated Go code back to
+// positions in the template.
+func (t *Template) SourceMap() (*SourceMap, error) {
+	src, out, g, err := t.generate()
+	if err != nil {
+		return nil, err
+	}
+	return newSourceMap(src.Bytes(), out.Bytes(), g.spans)
+}
+
+// generate returns the unformatted & formatted Go code for the template.
+// If the code cannot be formatted then the unformatted code is returned
+// along with the error.
+func (t *Template) generate() (src, out *bytes.Buffer, g *generator, err error) {
+	return t.generateWith(newTypeResolver(t))
+}
+
+// generateWith generates the Go code for the template using r to find the
+// types of component fields. If r is nil, the types of closure parameters
+// are written as interface{} which is sufficient to read declarations.
+func (t *Template) generateWith(r *typeResolver) (src, out *bytes.Buffer, g *generator, err error) {
 	var buf bytes.Buffer
 
 	// Write "generated" header comment.
-	buf.WriteString("// Generated by ego.\n")
-	buf.WriteString("// DO NOT EDIT\n\n")
+	const header = "// Generated by ego.\n// DO NOT EDIT\n\n"
+	buf.WriteString(header)
 
 	// Write blocks.
-	writeBlocksTo(&buf, t.Blocks)
+	g = &generator{opts: t.Options, resolver: r}
+	g.writeBlocksTo(&buf, trimLeadingBlocks(t.Blocks))
+	if g.err != nil {
+		return &buf, nil, g, g.err
+	}
+
+	// Write the blocks again if any printed values have known types so they
+	// are written by the functions for their types.
+	if funcs := findPrintFuncs(buf.Bytes(), g.prints); hasPrintFunc(funcs) {
+		buf.Truncate(len(header))
+		g = &generator{opts: t.Options, resolver: r, printFuncs: funcs}
+		g.writeBlocksTo(&buf, trimLeadingBlocks(t.Blocks))
+		if g.err != nil {
+			return &buf, nil, g, g.err
+		}
+	}
 
 	// Parse buffer as a Go file.
 	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, "", buf.Bytes(), parser.ParseComments)
 	if err != nil {
-		n, _ = buf.WriteTo(w)
-		return n, err
+		return &buf, nil, g, err
 	}
 
 	// Inject required packages.
-	injectImports(f)
+	injectImports(f, g.runtime)
 
 	// Attempt to gofmt.
 	var result bytes.Buffer
 	if err := format.Node(&result, fset, f); err != nil {
-		n, _ = buf.WriteTo(w)
-		return n, err
+		return &buf, nil, g, err
 	}
+	return &buf, &result, g, nil
+}
 
-	// Write to output writer.
-	return result.WriteTo(w)
+// hasPrintFunc returns true if any printed value has a known type.
+func hasPrintFunc(funcs []printFunc) bool {
+	for _, fn := range funcs {
+		if fn.prefix != "" {
+			return true
+		}
+	}
+	return false
 }
 
-func writeBlocksTo(buf *bytes.Buffer, blks []Block) {
-	for _, blk := range blks {
-		// Write line comment.
-		if pos := Position(blk); pos.Path != "" && pos.LineNo > 0 {
-			fmt.Fprintf(buf, "//line %s:%d\n", pos.Path, pos.LineNo)
+// trimLeadingBlocks removes whitespace text blocks before the first code
+// block, such as the newline after a pragma comment. They cannot be written
+// since they appear before the package clause.
+func trimLeadingBlocks(blks []Block) []Block {
+	for i, blk := range blks {
+		switch blk := blk.(type) {
+		case *CommentBlock:
+			continue
+		case *TextBlock:
+			if strings.TrimSpace(blk.Content) == "" {
+				continue
+			}
 		}
+		return blks[i:]
+	}
+	return nil
+}
+
+// generator holds the state used while writing blocks as Go code.
+type generator struct {
+	opts Options
+
+	// HTML context at the current position in the template text.
+	// Used to choose the escaping function for print blocks.
+	ctx escapeContext
+
+	// Set if the generated code references the ego runtime package.
+	runtime bool
+
+	// Ranges of the generated code copied from the template.
+	spans []sourceSpan
+
+	// Text written from package-level byte slices.
+	texts []string
+
+	// Offsets of the values printed by writePrintBlockTo.
+	prints []int
+
+	// Calls used for printed values of known types, by print order.
+	// Found from the prints of a previous pass over the template.
+	printFuncs []printFunc
+
+	// Finds the types of closure parameters. May be nil.
+	resolver *typeResolver
+
+	// First error that occurred while writing blocks.
+	err error
+}
+
+// writeSource writes Go code copied from the template at pos and records
+// its span so generated positions can be mapped back to the template.
+func (g *generator) writeSource(buf *bytes.Buffer, content string, pos Pos) {
+	if pos.LineNo > 0 && pos.ColNo > 0 {
+		g.spans = append(g.spans, sourceSpan{offset: buf.Len(), content: content, pos: pos})
+	}
+	buf.WriteString(content)
+}
+
+func (g *generator) writeBlocksTo(buf *bytes.Buffer, blks []Block) {
+	for i, blk := range blks {
+		// Trim whitespace around text blocks next to trim markers.
+		// Skip the block entirely if no content remains.
+		if txt, ok := blk.(*TextBlock); ok {
+			if blk = trimTextBlock(txt, blks, i); blk == nil {
+				continue
+			}
+		}
+
+		// Comments are not written to the generated code.
+		if _, ok := blk.(*CommentBlock); ok {
+			continue
+		}
+
+		// Write line comment.
+		writeLineDirective(buf, Position(blk))
 
 		// Write block.
 		switch blk := blk.(type) {
 		case *TextBlock:
-			fmt.Fprintf(buf, `_, _ = io.WriteString(w, %q)`+"\n", blk.Content)
+			g.beginWrite(buf)
+			if g.opts.StaticText {
+				fmt.Fprintf(buf, `w.Write(%s)`, staticTextName(blk.Content))
+				g.texts = append(g.texts, blk.Content)
+			} else {
+				fmt.Fprintf(buf, `io.WriteString(w, %q)`, blk.Content)
+			}
+			g.endWrite(buf)
+			g.ctx = g.ctx.advance(blk.Content)
 
 		case *CodeBlock:
-			fmt.Fprintln(buf, blk.Content)
+			g.writeSource(buf, blk.Content, blk.Pos.advanceString(openDelim(blk.TrimLeft, "")))
+			buf.WriteString("\n")
+			if g.opts.CheckContext && opensLoop(blk.Content) {
+				g.writeContextCheck(buf)
+			}
 
 		case *PrintBlock:
-			fmt.Fprintf(buf, `_, _ = io.WriteString(w, html.EscapeString(fmt.Sprint(%s)))`+"\n", blk.Content)
+			g.writePrintBlockTo(buf, blk)
 
 		case *RawPrintBlock:
-			fmt.Fprintf(buf, `_, _ = fmt.Fprint(w, %s)`+"\n", blk.Content)
+			g.beginWrite(buf)
+			buf.WriteString(`fmt.Fprint(w, `)
+			g.writeSource(buf, blk.Content, blk.Pos.advanceString(openDelim(blk.TrimLeft, "==")))
+			buf.WriteString(")")
+			g.endWrite(buf)
 
 		case *ComponentStartBlock:
-			if blk.Package != "" {
-				fmt.Fprintf(buf, "{\nvar EGO %s.%s\n", blk.Package, blk.Name)
-			} else {
-				fmt.Fprintf(buf, "{\nvar EGO %s\n", blk.Name)
-			}
+			g.writeComponentVar(buf, blk)
 
 			for _, field := range blk.Fields {
-				fmt.Fprintf(buf, "EGO.%s = %s\n", field.Name, field.Value)
+				g.beginCond(buf, field.Cond, field.CondPos)
+				writeLineDirective(buf, field.NamePos)
+				buf.WriteString("EGO.")
+				g.writeSource(buf, field.Name, field.NamePos)
+				buf.WriteString(" = ")
+				g.writeSource(buf, field.Value, field.ValuePos)
+				buf.WriteString("\n")
+				g.endCond(buf, field.Cond)
 			}
 
-			if len(blk.Attrs) > 0 {
-				fmt.Fprintf(buf, "EGO.Attrs = map[string]string{\n")
-				for _, attr := range blk.Attrs {
-					fmt.Fprintf(buf, "	%q: fmt.Sprint(%s),\n", attr.Name, attr.Value)
+			// Conditional attributes are merged after the other attributes.
+			var attrs, condAttrs []*Attr
+			for _, attr := range blk.Attrs {
+				if attr.Cond != "" {
+					condAttrs = append(condAttrs, attr)
+				} else {
+					attrs = append(attrs, attr)
 				}
-				fmt.Fprintf(buf, "}\n")
+			}
+			if len(attrs) > 0 || len(blk.Spreads) > 0 {
+				buf.WriteString("EGO.Attrs = ")
+				g.writeAttrs(buf, attrs, blk.Spreads)
+			}
+			for _, attr := range condAttrs {
+				g.beginCond(buf, attr.Cond, attr.CondPos)
+				buf.WriteString("EGO.Attrs = ")
+				g.writeAttrs(buf, []*Attr{attr}, []*Spread{{Value: "EGO.Attrs"}})
+				g.endCond(buf, attr.Cond)
 			}
 
+			// Closures are written by the component so their content
+			// starts in the context of the component tag and does not
+			// affect the context of the content following the component.
+			ctx := g.ctx
 			for _, attrBlock := range blk.AttrBlocks {
-				fmt.Fprintf(buf, "EGO.%s = func() {\n", attrBlock.Name)
-				writeBlocksTo(buf, attrBlock.Yield)
-				fmt.Fprint(buf, "}\n")
+				fmt.Fprintf(buf, "EGO.%s = ", attrBlock.Name)
+				g.writeClosure(buf, blk, attrBlock.Name, attrBlock.Lets, attrBlock.Yield)
+				g.ctx = ctx
+			}
+
+			if len(blk.Yield) > 0 || len(blk.Lets) > 0 {
+				buf.WriteString("EGO.Yield = ")
+				g.writeClosure(buf, blk, "Yield", blk.Lets, blk.Yield)
+				g.ctx = ctx
 			}
 
-			if len(blk.Yield) > 0 {
-				buf.WriteString("EGO.Yield = func() {\n")
-				writeBlocksTo(buf, blk.Yield)
-				buf.WriteString("}\n")
+			if g.opts.CheckContext {
+				g.writeContextCheck(buf)
+			}
+			if g.opts.ReturnErrors {
+				buf.WriteString("if err := EGO.Render(ctx, w); err != nil {\nreturn err\n}\n}\n")
+			} else {
+				fmt.Fprint(buf, "EGO.Render(ctx, w) }\n")
+			}
+		}
+	}
+}
+
+// writeComponentVar opens a block declaring the component as EGO. With the
+// Construct option, the component is created by its constructor, if it has
+// one, & is a pointer if its constructor or Render method uses a pointer.
+func (g *generator) writeComponentVar(buf *bytes.Buffer, blk *ComponentStartBlock) {
+	var ctor string
+	var ptr bool
+	if g.opts.Construct && g.resolver != nil {
+		var err error
+		if ctor, ptr, err = g.resolver.constructor(blk); err != nil && g.err == nil {
+			g.err = NewSyntaxError(blk.namePos(), "%s", err)
+		}
+	}
+
+	buf.WriteString("{\nvar EGO ")
+	if ptr {
+		buf.WriteString("*")
+	}
+	g.writeSource(buf, blk.qualifiedName(), blk.namePos())
+
+	switch {
+	case ctor != "" && blk.Package != "":
+		fmt.Fprintf(buf, " = %s.%s()", blk.Package, ctor)
+	case ctor != "":
+		fmt.Fprintf(buf, " = %s()", ctor)
+	case ptr:
+		buf.WriteString(" = &")
+		buf.WriteString(blk.qualifiedName())
+		buf.WriteString("{}")
+	}
+	buf.WriteString("\n")
+}
+
+// writeAttrs writes an expression for the passthrough attributes of a
+// component. Spreads are merged in order followed by the listed attributes.
+func (g *generator) writeAttrs(buf *bytes.Buffer, attrs []*Attr, spreads []*Spread) {
+	if len(spreads) == 0 && g.opts.TypedAttrs {
+		g.writeAttrMap(buf, attrs)
+		buf.WriteString("\n")
+		return
+	}
+
+	g.runtime = true
+	if len(spreads) == 0 {
+		buf.WriteString("ego.NewAttrs(\n")
+		for _, attr := range attrs {
+			writeLineDirective(buf, attr.NamePos)
+			fmt.Fprintf(buf, "%q, ", attr.Name)
+			g.writeAttrValue(buf, attr)
+			buf.WriteString(",\n")
+		}
+		buf.WriteString(")\n")
+		return
+	}
+
+	if g.opts.TypedAttrs {
+		buf.WriteString("ego.MergeTypedAttrs(\n")
+	} else {
+		buf.WriteString("ego.MergeAttrs(\n")
+	}
+	for _, spread := range spreads {
+		writeLineDirective(buf, spread.NamePos)
+		g.writeSource(buf, spread.Value, spread.ValuePos)
+		buf.WriteString(",\n")
+	}
+	if len(attrs) > 0 {
+		g.writeAttrMap(buf, attrs)
+		buf.WriteString(",\n")
+	}
+	buf.WriteString(")\n")
+}
+
+// writeAttrMap writes attributes as a map literal of their values. Only the
+// last attribute with a name is written since map keys must be unique.
+func (g *generator) writeAttrMap(buf *bytes.Buffer, attrs []*Attr) {
+	last := make(map[string]int)
+	for i, attr := range attrs {
+		last[attr.Name] = i
+	}
+
+	buf.WriteString("map[string]interface{}{\n")
+	for i, attr := range attrs {
+		if last[attr.Name] != i {
+			continue
+		}
+		writeLineDirective(buf, attr.NamePos)
+		fmt.Fprintf(buf, "%q: ", attr.Name)
+		g.writeAttrValue(buf, attr)
+		buf.WriteString(",\n")
+	}
+	buf.WriteString("}")
+}
+
+// writeAttrValue writes the value of an attribute. Attributes without a
+// value are boolean attributes.
+func (g *generator) writeAttrValue(buf *bytes.Buffer, attr *Attr) {
+	if attr.ValuePos == (Pos{}) && attr.Value == "" {
+		buf.WriteString("true")
+		return
+	}
+
+	// Quoted values are written by the template author & are trusted.
+	// Event handlers & styles from expressions are escaped unless they are
+	// of the trusted ego.JS & ego.CSS types.
+	var wrap string
+	if !strings.HasPrefix(attr.Value, `"`) && !strings.HasPrefix(attr.Value, "`") {
+		switch attrTypeOf(strings.ToLower(attr.Name)) {
+		case attrJS:
+			wrap = "ego.JSAttr"
+		case attrCSS:
+			wrap = "ego.CSSAttr"
+		}
+	}
+	if wrap == "" {
+		g.writeSource(buf, attr.Value, attr.ValuePos)
+		return
+	}
+
+	g.runtime = true
+	buf.WriteString(wrap + "(")
+	g.writeSource(buf, attr.Value, attr.ValuePos)
+	buf.WriteString(")")
+}
+
+// beginCond starts an if statement for the condition of a field or
+// attribute. Does nothing if there is no condition.
+func (g *generator) beginCond(buf *bytes.Buffer, cond string, pos Pos) {
+	if cond == "" {
+		return
+	}
+	writeLineDirective(buf, pos)
+	buf.WriteString("if ")
+	g.writeSource(buf, cond, pos)
+	buf.WriteString(" {\n")
+}
+
+// endCond ends an if statement started by beginCond.
+func (g *generator) endCond(buf *bytes.Buffer, cond string) {
+	if cond != "" {
+		buf.WriteString("}\n")
+	}
+}
+
+// writeClosure writes blocks as a function literal for the named field of a
+// component. If lets are specified, the parameters of the function are
+// declared with the types of the field's parameters.
+func (g *generator) writeClosure(buf *bytes.Buffer, blk *ComponentStartBlock, name string, lets []*Let, blks []Block) {
+	if len(lets) > 0 {
+		params, results, err := g.closureSignature(blk, name, lets)
+		if err != nil && g.err == nil {
+			g.err = NewSyntaxError(lets[0].NamePos, "%s", err)
+		}
+		fmt.Fprintf(buf, "func(%s) %s {\n", params, results)
+		g.writeBlocksTo(buf, blks)
+		if g.opts.ReturnErrors {
+			buf.WriteString("return nil\n")
+		}
+		buf.WriteString("}\n")
+		return
+	}
+
+	if !g.opts.ReturnErrors {
+		buf.WriteString("func() {\n")
+		g.writeBlocksTo(buf, blks)
+		buf.WriteString("}\n")
+		return
+	}
+
+	buf.WriteString("func() error {\n")
+	g.writeBlocksTo(buf, blks)
+	buf.WriteString("return nil\n}\n")
+}
+
+// closureSignature returns the parameters & results of a closure for the
+// named func field of a component. Parameters bound by lets are named by
+// the let's value & other parameters are blank.
+func (g *generator) closureSignature(blk *ComponentStartBlock, name string, lets []*Let) (params, results string, err error) {
+	// Without a resolver, only the names of the parameters are known.
+	if g.resolver == nil {
+		a := make([]string, len(lets))
+		for i, let := range lets {
+			a[i] = let.Value
+		}
+		if g.opts.ReturnErrors {
+			results = "error"
+		}
+		return strings.Join(a, ", ") + " interface{}", results, nil
+	}
+
+	ft, pkg, err := g.resolver.funcField(blk, name)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Bind each parameter to the let with the same name.
+	bound := make(map[string]bool)
+	var a []string
+	for _, field := range ft.Params.List {
+		typ, err := g.resolver.typeString(blk, pkg, field.Type)
+		if err != nil {
+			return "", "", err
+		}
+		if len(field.Names) == 0 {
+			return "", "", fmt.Errorf("Parameters of %s.%s must be named to use let", blk.qualifiedName(), name)
+		}
+		for _, ident := range field.Names {
+			param := "_"
+			for _, let := range lets {
+				if let.Name == ident.Name {
+					param, bound[let.Name] = let.Value, true
+				}
 			}
+			a = append(a, param+" "+typ)
+		}
+	}
+	for _, let := range lets {
+		if !bound[let.Name] {
+			return "", "", fmt.Errorf("%s.%s has no parameter named %s", blk.qualifiedName(), name, let.Name)
+		}
+	}
 
-			fmt.Fprint(buf, "EGO.Render(ctx, w) }\n")
+	// Closures return write errors with ego:errors & nothing otherwise, as
+	// for closures without lets.
+	var n int
+	if ft.Results != nil {
+		n = ft.Results.NumFields()
+	}
+	if !g.opts.ReturnErrors && n != 0 {
+		return "", "", fmt.Errorf("%s.%s must not return values to use let", blk.qualifiedName(), name)
+	} else if g.opts.ReturnErrors {
+		if n != 1 || !isErrorType(ft.Results.List[0].Type) {
+			return "", "", fmt.Errorf("%s.%s must return error to use let with ego:errors", blk.qualifiedName(), name)
 		}
+		results = "error"
+	}
+	return strings.Join(a, ", "), results, nil
+}
+
+// isErrorType returns true if expr is the error type.
+func isErrorType(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
+
+// beginWrite starts a statement that writes to w. The write error is
+// discarded unless the ReturnErrors option is set.
+func (g *generator) beginWrite(buf *bytes.Buffer) {
+	if g.opts.ReturnErrors {
+		buf.WriteString("if _, err := ")
+	} else {
+		buf.WriteString("_, _ = ")
+	}
+}
+
+// endWrite ends a statement started by beginWrite.
+func (g *generator) endWrite(buf *bytes.Buffer) {
+	if g.opts.ReturnErrors {
+		buf.WriteString("; err != nil {\nreturn err\n}\n")
+	} else {
+		buf.WriteString("\n")
+	}
+}
+
+// writeContextCheck writes a statement that stops rendering if ctx is done.
+func (g *generator) writeContextCheck(buf *bytes.Buffer) {
+	if g.opts.ReturnErrors {
+		buf.WriteString("if err := ctx.Err(); err != nil {\nreturn err\n}\n")
+		return
+	}
+	buf.WriteString("ego.CheckContext(ctx)\n")
+	g.runtime = true
+}
+
+// opensLoop returns true if the innermost block left open by the code is the
+// body of a for statement, such as "for _, x := range xs {".
+func opensLoop(code string) bool {
+	closes, opens := unmatchedBraces(code)
+	if opens == 0 {
+		return false
+	}
+
+	// Complete the code as the body of a function so it can be parsed.
+	prefix := "package p\nfunc _() {\n" + strings.Repeat("if _ {\n", closes)
+	src := prefix + code + "\n" + strings.Repeat("}\n", opens) + "}\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return false
+	}
+
+	// Find the last block opened within the code that is closed after it.
+	end := token.Pos(fset.File(f.Pos()).Base() + len(prefix) + len(code))
+	var last *ast.BlockStmt
+	var loop bool
+	ast.Inspect(f, func(node ast.Node) bool {
+		var body *ast.BlockStmt
+		var isLoop bool
+		switch node := node.(type) {
+		case *ast.ForStmt:
+			body, isLoop = node.Body, true
+		case *ast.RangeStmt:
+			body, isLoop = node.Body, true
+		case *ast.BlockStmt:
+			body = node
+		}
+		if body != nil && body.Lbrace < end && body.Rbrace > end && (last == nil || body.Lbrace > last.Lbrace) {
+			last, loop = body, isLoop
+		}
+		return true
+	})
+	return loop
+}
+
+// writeLineDirective writes a //line comment so that Go tools report
+// positions in the generated code at pos in the template.
+func writeLineDirective(buf *bytes.Buffer, pos Pos) {
+	if pos.Path != "" && pos.LineNo > 0 {
+		fmt.Fprintf(buf, "//line %s\n", pos)
+	}
+}
+
+// openDelim returns the opening delimiter of a code or print block.
+func openDelim(trimLeft bool, suffix string) string {
+	if trimLeft {
+		return "<%-" + suffix
+	}
+	return "<%" + suffix
+}
+
+// writePrintBlockTo writes a print block using the escaping function
+// for the HTML context that the block appears in.
+func (g *generator) writePrintBlockTo(buf *bytes.Buffer, blk *PrintBlock) {
+	g.ctx = g.ctx.beforePrint()
+	if err := g.ctx.err(); err != nil && g.err == nil {
+		g.err = NewSyntaxError(blk.Pos, "%s", err)
+	}
+
+	// Find the call for the value's type from a previous pass, if known.
+	var typed printFunc
+	if i := len(g.prints); i < len(g.printFuncs) {
+		typed = g.printFuncs[i]
+	}
+
+	var prefix, suffix string
+	fn := g.ctx.escaper()
+	switch {
+	case fn == "" && (g.ctx.state == stateTag || g.ctx.state == stateAfterName):
+		// Other values in a tag are written as attribute names.
+		prefix, suffix = `ego.WriteTagHTML(w, `, ")"
+		g.runtime = true
+	case fn == "" && g.ctx.state == stateAttrName:
+		prefix, suffix = `io.WriteString(w, ego.EscapeAttrName(`, "))"
+		g.runtime = true
+	case fn == "" && typed.prefix != "":
+		// Basic types are never trusted HTML so they are escaped by the
+		// function for their type without converting them to an interface.
+		prefix, suffix = typed.prefix, typed.suffix
+		g.runtime = true
+	case fn == "" && g.ctx.state == stateText:
+		// Only element content & tags, such as for ego.Attrs, can contain
+		// trusted HTML.
+		prefix, suffix = `ego.WriteHTML(w, `, ")"
+		g.runtime = true
+	case fn == "":
+		prefix, suffix = `ego.WriteEscaped(w, `, ")"
+		g.runtime = true
+	case fn == "EscapeUnquotedAttr":
+		prefix, suffix = `io.WriteString(w, ego.EscapeUnquotedAttr(`, "))"
+		g.runtime = true
+	case g.ctx.state == stateAttr && g.ctx.delim == 0:
+		// Unquoted attribute values are escaped after the context escaper
+		// so the value cannot end the attribute.
+		prefix, suffix = `io.WriteString(w, ego.EscapeUnquotedAttr(ego.`+fn+`(`, ")))"
+		g.runtime = true
+	case g.ctx.state == stateAttr:
+		// Attribute values are HTML escaped after the context escaper.
+		prefix, suffix = `io.WriteString(w, html.EscapeString(ego.`+fn+`(`, ")))"
+		g.runtime = true
+	default:
+		prefix, suffix = `io.WriteString(w, ego.`+fn+`(`, "))"
+		g.runtime = true
+	}
+
+	g.beginWrite(buf)
+	buf.WriteString(prefix)
+	g.prints = append(g.prints, buf.Len()+len(blk.Content)-len(strings.TrimLeft(blk.Content, " \t\r\n")))
+	g.writeSource(buf, blk.Content, blk.Pos.advanceString(openDelim(blk.TrimLeft, "=")))
+	buf.WriteString(suffix)
+	g.endWrite(buf)
+
+	g.ctx = g.ctx.afterPrint()
+}
+
+// trimTextBlock returns a copy of the text block at index i with whitespace
+// trimmed according to the trim markers of its neighboring blocks.
+// Returns nil if the block is empty after trimming.
+func trimTextBlock(blk *TextBlock, blks []Block, i int) Block {
+	other := *blk
+	if i > 0 {
+		if _, trimRight := trimMarkers(blks[i-1]); trimRight {
+			content := strings.TrimLeft(other.Content, " \t\r\n")
+			if other.Pos.ColNo > 0 {
+				other.Pos = other.Pos.advanceString(other.Content[:len(other.Content)-len(content)])
+			} else {
+				other.Pos.LineNo += strings.Count(other.Content[:len(other.Content)-len(content)], "\n")
+			}
+			other.Content = content
+		}
+	}
+	if i < len(blks)-1 {
+		if trimLeft, _ := trimMarkers(blks[i+1]); trimLeft {
+			other.Content = strings.TrimRight(other.Content, " \t\r\n")
+		}
+	}
+
+	if other.Content == "" {
+		return nil
+	}
+	return &other
+}
+
+// trimMarkers returns the trim markers set on a block.
+func trimMarkers(blk Block) (left, right bool) {
+	switch blk := blk.(type) {
+	case *CodeBlock:
+		return blk.TrimLeft, blk.TrimRight
+	case *PrintBlock:
+		return blk.TrimLeft, blk.TrimRight
+	case *RawPrintBlock:
+		return blk.TrimLeft, blk.TrimRight
+	default:
+		return false, false
 	}
 }
 
@@ -154,8 +798,15 @@ func trimTrailingEmptyTextBlocks(a []Block) []Block {
 	return a
 }
 
-func injectImports(f *ast.File) {
+// runtimeImportPath is the import path of the package used by generated code.
+// It is imported with the name "ego".
+const runtimeImportPath = `"github.com/benbjohnson/ego/runtime"`
+
+func injectImports(f *ast.File, runtime bool) {
 	names := []string{`"fmt"`, `"html"`, `"io"`, `"context"`}
+	if runtime {
+		names = append(names, runtimeImportPath)
+	}
 
 	// Strip packages from existing imports.
 	for i := 0; i < len(f.Decls); i++ {
@@ -178,11 +829,13 @@ func injectImports(f *ast.File) {
 
 	// Generate new import.
 	for i := len(names) - 1; i >= 0; i-- {
+		spec := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: names[i]}}
+		if names[i] == runtimeImportPath {
+			spec.Name = ast.NewIdent("ego")
+		}
 		f.Decls = append([]ast.Decl{&ast.GenDecl{
-			Tok: token.IMPORT,
-			Specs: []ast.Spec{
-				&ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: names[i]}},
-			},
+			Tok:   token.IMPORT,
+			Specs: []ast.Spec{spec},
 		}}, f.Decls...)
 	}
 
@@ -218,6 +871,8 @@ func removeImportSpecs(decl *ast.GenDecl, names []string) {
 		spec, ok := decl.Specs[i].(*ast.ImportSpec)
 		if !ok || !stringSliceContains(names, spec.Path.Value) {
 			continue
+		} else if spec.Path.Value == runtimeImportPath && (spec.Name == nil || spec.Name.Name != "ego") {
+			continue // imported by the template under another name
 		}
 
 		// Delete spec.
@@ -236,6 +891,7 @@ type Block interface {
 
 func (*TextBlock) block()           {}
 func (*CodeBlock) block()           {}
+func (*CommentBlock) block()        {}
 func (*PrintBlock) block()          {}
 func (*RawPrintBlock) block()       {}
 func (*ComponentStartBlock) block() {}
@@ -253,18 +909,35 @@ type TextBlock struct {
 type CodeBlock struct {
 	Pos     Pos
 	Content string
+
+	// Set by "<%-" and "-%>" markers to trim whitespace from the
+	// preceding and following text blocks, respectively.
+	TrimLeft  bool
+	TrimRight bool
+}
+
+// CommentBlock represents a template comment. It is not written to the generated code.
+type CommentBlock struct {
+	Pos     Pos
+	Content string
 }
 
 // PrintBlock represents a block that will HTML escape the contents before outputting
 type PrintBlock struct {
 	Pos     Pos
 	Content string
+
+	TrimLeft  bool
+	TrimRight bool
 }
 
 // RawPrintBlock represents a block of the template that is printed out to the writer.
 type RawPrintBlock struct {
 	Pos     Pos
 	Content string
+
+	TrimLeft  bool
+	TrimRight bool
 }
 
 // ComponentStartBlock represents the opening block of an ego component.
@@ -275,6 +948,8 @@ type ComponentStartBlock struct {
 	Closed     bool
 	Fields     []*Field
 	Attrs      []*Attr
+	Spreads    []*Spread
+	Lets       []*Let
 	AttrBlocks []*AttrStartBlock
 	Yield      []Block
 }
@@ -287,6 +962,23 @@ func (blk *ComponentStartBlock) Namespace() string {
 	return blk.Package
 }
 
+// qualifiedName returns the component type name as referenced in Go code.
+func (blk *ComponentStartBlock) qualifiedName() string {
+	if blk.Package == "" {
+		return blk.Name
+	}
+	return blk.Package + "." + blk.Name
+}
+
+// namePos returns the position of the qualified name in the template.
+// The "pkg:Name" form in the tag has the same length as "pkg.Name".
+func (blk *ComponentStartBlock) namePos() Pos {
+	if blk.Package == "" {
+		return blk.Pos.advanceString("<ego:")
+	}
+	return blk.Pos.advanceString("<")
+}
+
 // ComponentEndBlock represents the closing block of an ego component.
 type ComponentEndBlock struct {
 	Pos     Pos
@@ -307,6 +999,7 @@ type AttrStartBlock struct {
 	Pos     Pos
 	Package string
 	Name    string
+	Lets    []*Let
 	Yield   []Block
 }
 
@@ -355,6 +1048,11 @@ type Field struct {
 
 	Value    string
 	ValuePos Pos
+
+	// Condition from a following "ego:if=cond", if any. The field is only
+	// set when the condition is true.
+	Cond    string
+	CondPos Pos
 }
 
 // Attr represents a key/value passthrough pair on a component.
@@ -364,6 +1062,32 @@ type Attr struct {
 
 	Value    string
 	ValuePos Pos
+
+	// Condition from a following "ego:if=cond", if any. The attribute is
+	// only set when the condition is true.
+	Cond    string
+	CondPos Pos
+}
+
+// Spread represents a map of passthrough attributes on a component, such as
+// "ego:attrs=r.Attrs". Attributes listed on the component replace attributes
+// in the map with the same name.
+type Spread struct {
+	NamePos Pos
+
+	Value    string
+	ValuePos Pos
+}
+
+// Let represents a parameter of a closure bound to a variable, such as
+// let:item="row". Name is the name of the parameter in the type of the
+// component's func field & Value is the name of the variable.
+type Let struct {
+	Name    string
+	NamePos Pos
+
+	Value    string
+	ValuePos Pos
 }
 
 // Position returns the position of the block.
@@ -373,6 +1097,8 @@ func Position(blk Block) Pos {
 		return blk.Pos
 	case *CodeBlock:
 		return blk.Pos
+	case *CommentBlock:
+		return blk.Pos
 	case *PrintBlock:
 		return blk.Pos
 	case *RawPrintBlock:
@@ -392,8 +1118,40 @@ func Position(blk Block) Pos {
 
 // Pos represents a position in a given file.
 type Pos struct {
-	Path   string
-	LineNo int
+	Path      string
+	LineNo    int
+	ColNo     int // column in bytes, starting at 1
+	RuneColNo int // column in runes, starting at 1
+	Offset    int // byte offset, starting at 0
+}
+
+// String returns the position in "path:line:col" format.
+// The column is omitted if it is not set.
+func (p Pos) String() string {
+	if p.ColNo == 0 {
+		return fmt.Sprintf("%s:%d", p.Path, p.LineNo)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Path, p.LineNo, p.ColNo)
+}
+
+// advance returns the position after a rune of n bytes.
+func (p Pos) advance(ch rune, n int) Pos {
+	p.Offset += n
+	if ch == '\n' {
+		p.LineNo, p.ColNo, p.RuneColNo = p.LineNo+1, 1, 1
+	} else {
+		p.ColNo, p.RuneColNo = p.ColNo+n, p.RuneColNo+1
+	}
+	return p
+}
+
+// advanceString returns the position after s.
+func (p Pos) advanceString(s string) Pos {
+	for len(s) > 0 {
+		ch, n := utf8.DecodeRuneInString(s)
+		p, s = p.advance(ch, n), s[n:]
+	}
+	return p
 }
 
 func stringSliceContains(a []string, v string) bool {
@@ -2,6 +2,12 @@ package ego_test
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/benbjohnson/ego"
@@ -29,3 +35,785 @@ func TestTemplate_Write(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// Ensure that print blocks are escaped based on their HTML context.
+func TestTemplate_WriteTo_Escape(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text string
+		want string
+	}{
+		{"Text", `<p><%= x %></p>`, `ego.WriteHTML(w, x)`},
+		{"Tag", `<p class="a"<%= x %>>`, `ego.WriteTagHTML(w, x)`},
+		{"AttrName", `<p data-<%= x %>="1">`, `io.WriteString(w, ego.EscapeAttrName(x))`},
+		{"QuotedAttr", `<p class="<%= x %>">`, `ego.WriteEscaped(w, x)`},
+		{"UnquotedAttr", `<p class=<%= x %>>`, `io.WriteString(w, ego.EscapeUnquotedAttr(x))`},
+		{"URL", `<a href="<%= x %>">`, `io.WriteString(w, html.EscapeString(ego.EscapeURL(x)))`},
+		{"UnquotedURL", `<a href=<%= x %>>`, `io.WriteString(w, ego.EscapeUnquotedAttr(ego.EscapeURL(x)))`},
+		{"URLPath", `<a href="/users/<%= x %>">`, `io.WriteString(w, html.EscapeString(ego.NormalizeURL(x)))`},
+		{"URLQuery", `<a href="/users?id=<%= x %>">`, `io.WriteString(w, html.EscapeString(ego.EscapeURLQuery(x)))`},
+		{"JSAttr", `<a onclick="go(<%= x %>)">`, `io.WriteString(w, html.EscapeString(ego.EscapeJSAttrValue(x)))`},
+		{"UnquotedJSAttr", `<a onclick=<%= x %>>`, `io.WriteString(w, ego.EscapeUnquotedAttr(ego.EscapeJSAttrValue(x)))`},
+		{"JSAttrString", `<a onclick="go('<%= x %>')">`, `io.WriteString(w, html.EscapeString(ego.EscapeJSString(x)))`},
+		{"Script", `<script>var x = <%= x %>;</script>`, `io.WriteString(w, ego.EscapeJSValue(x))`},
+		{"ScriptString", `<script>var x = "a<%= x %>";</script>`, `io.WriteString(w, ego.EscapeJSString(x))`},
+		{"ScriptLineComment", "<script>// don't\nvar x = <%= x %>;</script>", `io.WriteString(w, ego.EscapeJSValue(x))`},
+		{"ScriptBlockComment", `<script>/* don't */ var x = <%= x %>;</script>`, `io.WriteString(w, ego.EscapeJSValue(x))`},
+		{"ScriptInComment", `<script>/* <%= x %> */</script>`, `io.WriteString(w, ego.EscapeJSString(x))`},
+		{"ScriptRegexp", `<script>var re = /['"]/; var x = <%= x %>;</script>`, `io.WriteString(w, ego.EscapeJSValue(x))`},
+		{"ScriptInRegexp", `<script>var re = /a<%= x %>/;</script>`, `io.WriteString(w, ego.EscapeJSRegexp(x))`},
+		{"ScriptDivision", `<script>var x = a / 2, y = '<%= x %>';</script>`, `io.WriteString(w, ego.EscapeJSString(x))`},
+		{"ScriptAfterAmbiguous", "<script>if (a) /'/.test(s);\nvar x = <%= x %>;</script>", `io.WriteString(w, ego.EscapeJSValue(x))`},
+		{"ScriptTemplate", "<script>var s = `a<%= x %>`;</script>", `io.WriteString(w, ego.EscapeJSTemplate(x))`},
+		{"ScriptInTemplateExpr", "<script>var s = `a${<%= x %>}`;</script>", `io.WriteString(w, ego.EscapeJSValue(x))`},
+		{"ScriptAfterTemplateExpr", "<script>var s = `a${ f({a: '}'}) }<%= x %>`;</script>", `io.WriteString(w, ego.EscapeJSTemplate(x))`},
+		{"Style", `<style>p { color: <%= x %> }</style>`, `io.WriteString(w, ego.EscapeCSS(x))`},
+		{"StyleAttr", `<p style="color: <%= x %>">`, `io.WriteString(w, html.EscapeString(ego.EscapeCSS(x)))`},
+		{"UnquotedStyleAttr", `<p style=<%= x %>>`, `io.WriteString(w, ego.EscapeUnquotedAttr(ego.EscapeCSS(x)))`},
+		{"AfterScript", `<script>var x = "</script>"; <p><%= x %></p>`, `ego.WriteHTML(w, x)`},
+		{"AfterAttr", `<a href="/"><%= x %></a>`, `ego.WriteHTML(w, x)`},
+		{"Comment", `<!-- <script> --><%= x %>`, `ego.WriteHTML(w, x)`},
+		{"InComment", `<!-- <%= x %> -->`, `ego.WriteEscaped(w, x)`},
+		{"RCDATA", `<title><%= x %></title>`, `ego.WriteEscaped(w, x)`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>"+tt.text+"<% } %>"), "tmpl.ego")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := tmpl.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			} else if !strings.Contains(buf.String(), tt.want) {
+				t.Fatalf("expected %q in output:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+// Ensure that values with types known from the template are printed by the
+// write function for their type.
+func TestTemplate_WriteTo_Typed(t *testing.T) {
+	const decls = "<% package foo\ntype Page struct { Name string; Count int; Size uint8; Score float32; Body []byte; Other Other }\nfunc (p *Page) Render(ctx context.Context, w io.Writer) { n := len(p.Name) %>"
+	for _, tt := range []struct {
+		name string
+		text string
+		want string
+	}{
+		{"String", `<p><%= p.Name %></p>`, `ego.WriteEscapedString(w, p.Name)`},
+		{"StringAttr", `<p title="<%= p.Name %>">`, `ego.WriteEscapedString(w, p.Name)`},
+		{"StringLiteral", `<p><%= "a" %></p>`, `ego.WriteEscapedString(w, "a")`},
+		{"Bytes", `<p><%= p.Body %></p>`, `ego.WriteEscapedBytes(w, p.Body)`},
+		{"Int", `<p><%= p.Count %></p>`, `ego.WriteInt(w, int64(p.Count))`},
+		{"Local", `<p><%= n %></p>`, `ego.WriteInt(w, int64(n))`},
+		{"Rune", `<p><%= 'a' %></p>`, `ego.WriteInt(w, int64('a'))`},
+		{"Uint", `<p><%= p.Size %></p>`, `ego.WriteUint(w, uint64(p.Size))`},
+		{"Float32", `<p><%= p.Score %></p>`, `ego.WriteFloat(w, float64(p.Score), 32)`},
+		{"FloatLiteral", `<p><%= 1.5 %></p>`, `ego.WriteFloat(w, float64(1.5), 64)`},
+		{"Bool", `<p><%= n > 1 %></p>`, `ego.WriteBool(w, n > 1)`},
+		{"Range", `<% for _, s := range []string{"a"} { %><p><%= s %></p><% } %>`, `ego.WriteEscapedString(w, s)`},
+		{"OtherFile", `<p><%= p.Other %></p>`, `ego.WriteHTML(w, p.Other)`},
+		{"OtherPackage", `<p><%= strconv.Itoa(n) %></p>`, `ego.WriteHTML(w, strconv.Itoa(n))`},
+		{"Tag", `<p <%= p.Name %>>`, `ego.WriteTagHTML(w, p.Name)`},
+		{"Script", `<script>var x = <%= p.Name %>;</script>`, `io.WriteString(w, ego.EscapeJSValue(p.Name))`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ego.Parse(strings.NewReader(decls+tt.text+"<% } %>"), "tmpl.ego")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := tmpl.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			} else if !strings.Contains(buf.String(), tt.want) {
+				t.Fatalf("expected %q in output:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+// Ensure that values cannot be printed where the JavaScript context is unknown.
+func TestTemplate_WriteTo_Escape_Err(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"Ambiguous", "<script>var x = (a+b) / 2; var y = \"<%= x %>\";</script>", "tmpl.ego:2:87: Cannot print in JavaScript after a '/' which may start a regular expression, move the value to the next line"},
+		{"AmbiguousAttr", `<a onclick="f() / 2; g('<%= x %>')">`, "tmpl.ego:2:75: Cannot print in JavaScript after a '/' which may start a regular expression, move the value to the next line"},
+		{"NestedTemplate", "<script>var s = `${ `${a}` } <%= x %>`;</script>", "tmpl.ego:2:80: Cannot print in a nested JavaScript template literal"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>"+tt.text+"<% } %>"), "tmpl.ego")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || err.Error() != tt.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// Ensure that printed values are escaped once & cannot leave their context
+// when the generated code is run.
+func TestTemplate_WriteTo_Escape_Render(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		x    string // Go expression for the printed value
+		want string
+	}{
+		{"Attrs", `<p class="a"<%= x %>>`, `ego.NewAttrs("onclick", "save()", "onfocus", ego.JSAttr("alert(1)"), "title", "<t>")`, `<p class="a" onclick="save()" onfocus="&#34;alert(1)&#34;" title="&lt;t&gt;">`},
+		{"QuotedAttr", `<p class="<%= x %>">`, `"a&b c"`, `<p class="a&amp;b c">`},
+		{"UnquotedAttr", `<p class=<%= x %>>`, `"a&b c"`, `<p class=a&amp;b&#32;c>`},
+		{"UnquotedURL", `<a href=<%= x %>>`, `"/a?b=1&c=2"`, `<a href=/a?b&#61;1&amp;c&#61;2>`},
+		{"UnquotedJSAttr", `<a onmouseover=<%= x %>>`, `"x onclick=alert(1)"`, `<a onmouseover=&#34;x&#32;onclick&#61;alert(1)&#34;>`},
+		{"JSAttr", `<a onclick="go(<%= x %>)">`, `"a'b"`, `<a onclick="go(&#34;a\u0027b&#34;)">`},
+		{"ScriptLineComment", "<script>// don't\nvar x = <%= x %>;</script>", `"1;alert(1)"`, "<script>// don't\nvar x =  \"1;alert(1)\" ;</script>"},
+		{"ScriptInRegexp", `<script>var re = /<%= x %>/;</script>`, `"a.b/c"`, `<script>var re = /a\.b\u002Fc/;</script>`},
+		{"ScriptAfterAmbiguous", "<script>if (a) /'/.test(s);\nvar x = <%= x %>;</script>", `"a/b"`, "<script>if (a) /'/.test(s);\nvar x =  \"a\\/b\" ;</script>"},
+		{"ScriptTemplate", "<script>var s = `<%= x %>`;</script>", `"${alert(1)}"`, "<script>var s = `\\u0024\\u007Balert(1)\\u007D`;</script>"},
+		{"AttrName", `<p data-<%= x %>="1">`, `"a onmouseover=alert(1) b"`, `<p data-ZgotmplZ="1">`},
+		{"TagString", `<p <%= x %>>`, `"onclick=alert(1)"`, `<p ZgotmplZ>`},
+		{"TypedString", `<p title="<%= x.(string) %>"><%= x.(string) %></p>`, `"a&b"`, `<p title="a&amp;b">a&amp;b</p>`},
+		{"TypedBytes", `<p><%= x.([]byte) %></p>`, `[]byte("<b>")`, `<p>&lt;b&gt;</p>`},
+		{"TypedNumbers", `<p><%= x.(int8) %> <%= uint(x.(int8)) %> <%= float32(x.(int8)) / 10 %> <%= x.(int8) > 0 %></p>`, `int8(3)`, `<p>3 3 0.3 true</p>`},
+	}
+
+	var bodies, values []string
+	for _, tt := range tests {
+		bodies, values = append(bodies, tt.text), append(values, tt.x)
+	}
+	outputs := renderTemplates(t, bodies, values)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if outputs[i] != tt.want {
+				t.Fatalf("unexpected output:\ngot:  %s\nwant: %s", outputs[i], tt.want)
+			}
+		})
+	}
+}
+
+// renderTemplates generates a program from templates with the given bodies
+// & returns the output of rendering each body with its value as x. The test
+// is skipped if the go command is not available.
+func renderTemplates(t *testing.T, bodies, values []string) []string {
+	t.Helper()
+	var srcs []string
+	var main bytes.Buffer
+	main.WriteString("package main\n\nimport (\n\t\"context\"\n\t\"os\"\n\n\tego \"github.com/benbjohnson/ego/runtime\"\n)\n\nvar _ ego.HTML\n\nfunc main() {\n")
+	for i, body := range bodies {
+		srcs = append(srcs, fmt.Sprintf("<%% package main\nfunc Render%d(ctx context.Context, w io.Writer, x interface{}) { %%>%s<%% } %%>", i, body))
+		fmt.Fprintf(&main, "\tRender%d(context.Background(), os.Stdout, %s)\n\tos.Stdout.WriteString(\"\\x00\")\n", i, values[i])
+	}
+	main.WriteString("}\n")
+
+	out := runTemplates(t, srcs, main.String())
+	return strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+}
+
+// runTemplates generates templates from their source & returns the output of
+// running them with the given main.go file. The test is skipped if the go
+// command is not available.
+func runTemplates(t *testing.T, srcs []string, main string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	} else if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+
+	root, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeFile := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("go.mod", fmt.Sprintf("module render\n\ngo 1.16\n\nrequire github.com/benbjohnson/ego v0.0.0\n\nreplace github.com/benbjohnson/ego => %s\n", root))
+
+	for i, src := range srcs {
+		name := fmt.Sprintf("tmpl%d.ego", i)
+		tmpl, err := ego.Parse(strings.NewReader(src), filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(name+".go", buf.String())
+	}
+	writeFile("main.go", main)
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %s\n%s", err, out)
+	}
+	return string(out)
+}
+
+// Ensure that trim markers remove whitespace from neighboring text blocks.
+func TestTemplate_WriteTo_Trim(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\n\t<ul>\n\t<%- for _, x := range xs { -%>\n\t\t<li><%= x %></li>\n\t<%- } -%>\n\t</ul>\n<% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`io.WriteString(w, "\n\t<ul>")`,
+		`io.WriteString(w, "<li>")`,
+		`io.WriteString(w, "</li>")`,
+		`io.WriteString(w, "</ul>\n")`,
+		"//line tmpl.ego:5:3\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+// Ensure that comment blocks are not written to the generated code.
+func TestTemplate_WriteTo_Comment(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><%# a\nmultiline\ncomment %><p><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(buf.String(), "multiline") {
+		t.Fatalf("unexpected comment in output:\n%s", buf.String())
+	} else if !strings.Contains(buf.String(), "//line tmpl.ego:4:11\n") {
+		t.Fatalf("expected line directive in output:\n%s", buf.String())
+	}
+}
+
+// Ensure that write errors are returned when the ReturnErrors option is set.
+func TestTemplate_WriteTo_ReturnErrors(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%# ego:errors %>\n<% package foo\nfunc Render(ctx context.Context, w io.Writer) error { %><p><%= x %><%== y %></p><ego:Card><ego::Header>h</ego::Header>body</ego:Card><% return nil } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	} else if !tmpl.Options.ReturnErrors {
+		t.Fatal("expected ReturnErrors option")
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"if _, err := io.WriteString(w, \"<p>\"); err != nil {\n\t\treturn err\n\t}",
+		"if _, err := ego.WriteHTML(w, x); err != nil {",
+		"if _, err := fmt.Fprint(w, y); err != nil {",
+		"EGO.Header = func() error {",
+		"EGO.Yield = func() error {",
+		"\t\t\treturn nil\n\t\t}",
+		"if err := EGO.Render(ctx, w); err != nil {\n\t\t\treturn err\n\t\t}",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+// Ensure that rendering stops at the first failed write & returns its error
+// when the generated code is run.
+func TestTemplate_WriteTo_ReturnErrors_Render(t *testing.T) {
+	const src = "<%# ego:errors %>\n<% package main\nfunc Render(ctx context.Context, w io.Writer, xs []int) error { %><p><% for _, x := range xs { %><%= x %>,<% } %></p><ego:Card><ego::Header>h</ego::Header>body</ego:Card><% return nil } %>"
+	const main = `package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type Card struct {
+	Header func() error
+	Yield  func() error
+}
+
+func (c *Card) Render(ctx context.Context, w io.Writer) error {
+	if _, err := io.WriteString(w, "<div>"); err != nil {
+		return err
+	} else if err := c.Header(); err != nil {
+		return err
+	} else if err := c.Yield(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "</div>")
+	return err
+}
+
+// limitWriter fails writes after n bytes.
+type limitWriter struct {
+	n   int
+	buf bytes.Buffer
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.n {
+		return 0, errors.New("write failed")
+	}
+	return w.buf.Write(p)
+}
+
+func main() {
+	for _, n := range []int{0, 4, 16, 19, 100} {
+		w := &limitWriter{n: n}
+		err := Render(context.Background(), w, []int{1, 2, 3})
+		fmt.Printf("%s|%v\n", w.buf.String(), err)
+	}
+}
+`
+	out := runTemplates(t, []string{src}, main)
+	if want := "" +
+		"|write failed\n" +
+		"<p>1|write failed\n" +
+		"<p>1,2,3,</p>|write failed\n" +
+		"<p>1,2,3,</p><div>h|write failed\n" +
+		"<p>1,2,3,</p><div>hbody</div>|<nil>\n"; out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+// Ensure that the context is checked in loops & before components.
+func TestTemplate_WriteTo_CheckContext(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		src   string
+		check string
+		count int
+	}{
+		{"Panic", "<%# ego:context %>\n", "ego.CheckContext(ctx)\n", 3},
+		{"ReturnErrors", "<%# ego:context ego:errors %>\n", "if err := ctx.Err(); err != nil {\n", 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src + "<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>" +
+				"<% for _, x := range xs { %><%= x %><% } %>" +
+				"<% for i := 0; i < n; i++ { %>,<% } %>" +
+				"<% if ok { f = func() { %>a<% } } %>" +
+				"<% if ok { %><ego:Card /><% } %><% } %>"
+			tmpl, err := ego.Parse(strings.NewReader(src), "tmpl.ego")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := tmpl.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			} else if n := strings.Count(buf.String(), tt.check); n != tt.count {
+				t.Fatalf("unexpected check count: %d\n%s", n, buf.String())
+			}
+		})
+	}
+}
+
+// Ensure that rendering stops with the context's error once it is done when
+// the generated code is run.
+func TestTemplate_WriteTo_CheckContext_Render(t *testing.T) {
+	srcs := []string{
+		"<%# ego:context %>\n<% package main\nfunc RenderPanic(ctx context.Context, w io.Writer, xs []int) { %><% for _, x := range xs { %><%= x %>,<% } %><ego:Item /><% } %>",
+		"<%# ego:context ego:errors %>\n<% package main\nfunc RenderErrors(ctx context.Context, w io.Writer, xs []int) error { %><% for _, x := range xs { %><%= x %>,<% } %><ego:ErrItem /><% return nil } %>",
+	}
+	const main = `package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	ego "github.com/benbjohnson/ego/runtime"
+)
+
+type Item struct{}
+
+func (Item) Render(ctx context.Context, w io.Writer) { io.WriteString(w, "[item]") }
+
+type ErrItem struct{}
+
+func (ErrItem) Render(ctx context.Context, w io.Writer) error {
+	_, err := io.WriteString(w, "[item]")
+	return err
+}
+
+// cancelWriter cancels the context once the output ends with after.
+type cancelWriter struct {
+	buf    bytes.Buffer
+	after  string
+	cancel func()
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, _ := w.buf.Write(p)
+	if strings.HasSuffix(w.buf.String(), w.after) {
+		w.cancel()
+	}
+	return n, nil
+}
+
+func renderPanic(ctx context.Context, w io.Writer, xs []int) (err error) {
+	defer ego.Recover(&err)
+	RenderPanic(ctx, w, xs)
+	return nil
+}
+
+func main() {
+	for _, render := range []func(context.Context, io.Writer, []int) error{renderPanic, RenderErrors} {
+		for _, after := range []string{"", "2,", "3,", "never"} {
+			ctx, cancel := context.WithCancel(context.Background())
+			if after == "" {
+				cancel()
+			}
+			w := &cancelWriter{after: after, cancel: cancel}
+			err := render(ctx, w, []int{1, 2, 3})
+			cancel()
+			fmt.Printf("%s|%v\n", w.buf.String(), err)
+		}
+	}
+}
+`
+	out := runTemplates(t, srcs, main)
+	const want = "" +
+		"|context canceled\n" +
+		"1,2,|context canceled\n" +
+		"1,2,3,|context canceled\n" +
+		"1,2,3,[item]|<nil>\n"
+	if out != want+want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+// Ensure that component attributes are passed as ego.Attrs.
+func TestTemplate_WriteTo_Attrs(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Card Title=\"x\" class=\"a\" disabled data-x=user.Name onclick=\"save()\" onfocus=user.Input style=user.Style /><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"EGO.Attrs = ego.NewAttrs(\n",
+		"\t\"class\", \"a\",\n",
+		"\t\"disabled\", true,\n",
+		"\t\"data-x\", user.Name,\n",
+		"\t\"onclick\", \"save()\",\n",
+		"\t\"onfocus\", ego.JSAttr(user.Input),\n",
+		"\t\"style\", ego.CSSAttr(user.Style),\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+// Ensure that component attributes keep their values with the TypedAttrs option.
+func TestTemplate_WriteTo_TypedAttrs(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%# ego:typedattrs %>\n<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Card class=\"a\" disabled class=classes /><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	} else if !tmpl.Options.TypedAttrs {
+		t.Fatal("expected TypedAttrs option")
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(buf.String(), `"class": "a"`) {
+		t.Fatalf("unexpected duplicate attribute:\n%s", buf.String())
+	}
+	for _, want := range []string{
+		"EGO.Attrs = map[string]interface{}{\n",
+		"\t\"disabled\": true,\n",
+		"\t\"class\": classes,\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+// Ensure that spread attributes are merged before the listed attributes.
+func TestTemplate_WriteTo_Spread(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"Default", "", "EGO.Attrs = ego.MergeAttrs(\n"},
+		{"TypedAttrs", "<%# ego:typedattrs %>\n", "EGO.Attrs = ego.MergeTypedAttrs(\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ego.Parse(strings.NewReader(tt.src+"<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Card ego:attrs=r.Attrs class=\"a\" disabled /><% } %>"), "tmpl.ego")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := tmpl.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{
+				tt.want,
+				"\tr.Attrs,\n",
+				"\tmap[string]interface{}{\n",
+				"\t\t\"class\": \"a\",\n",
+				"\t\t\"disabled\": true,\n",
+			} {
+				if !strings.Contains(buf.String(), want) {
+					t.Fatalf("expected %q in output:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+// Ensure that conditional fields & attributes are set in an if statement.
+func TestTemplate_WriteTo_Cond(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Link Href=u ego:if=(u != \"\") class=\"x\" class=\"active\" ego:if=selected /><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\t\tif u != \"\" {\n//line tmpl.ego:2:61\n\t\t\tEGO.Href = u\n\t\t}\n",
+		"\t\t\t\"class\", \"x\",\n",
+		"\t\tif selected {\n\t\t\tEGO.Attrs = ego.MergeAttrs(\n\t\t\t\tEGO.Attrs,\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+// Ensure that let attributes declare closure parameters with the types of the
+// component's func fields.
+func TestTemplate_WriteTo_Let(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "table.go"), []byte("package foo\n\ntype Item struct{}\n\ntype Table struct {\n\tRow   func(item Item, i int) error\n\tYield func(total int) error\n}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(t *testing.T, src string) *ego.Template {
+		path := filepath.Join(dir, "tmpl.ego")
+		if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := ego.ParseFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		tmpl := parse(t, "<%# ego:errors %><% package foo\nfunc Render(ctx context.Context, w io.Writer) error { %><ego:Table let:total=\"n\"><ego::Row let:i=\"idx\"><%= idx %></ego::Row><%= n %></ego:Table><% return nil } %>")
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"EGO.Row = func(_ Item, idx int) error {\n",
+			"\t\t\treturn nil\n\t\t}\n\t\tEGO.Yield = func(n int) error {\n",
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Fatalf("expected %q in output:\n%s", want, buf.String())
+			}
+		}
+	})
+
+	t.Run("ErrUnknownParameter", func(t *testing.T) {
+		tmpl := parse(t, "<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Table><ego::Row let:row=\"x\"></ego::Row></ego:Table><% } %>")
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || !strings.HasSuffix(err.Error(), "tmpl.ego:2:72: Table.Row has no parameter named row") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNoErrorResult", func(t *testing.T) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "list.go"), []byte("package foo\n\ntype List struct {\n\tYield func(i int)\n}\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		tmpl := parse(t, "<%# ego:errors %><% package foo\nfunc Render(ctx context.Context, w io.Writer) error { %><ego:List let:i=\"i\"><%= i %></ego:List><% return nil } %>")
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || !strings.HasSuffix(err.Error(), "List.Yield must return error to use let with ego:errors") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNoField", func(t *testing.T) {
+		tmpl := parse(t, "<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Item let:x=\"y\"></ego:Item><% } %>")
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || !strings.HasSuffix(err.Error(), "Item has no field Yield") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure closure parameters use the template's names for the packages of
+// types used by a component in another package.
+func TestTemplate_WriteTo_Let_OtherPackage(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"go.mod":        "module example.com/app\n\ngo 1.18\n",
+		"ui/table.go":   "package ui\n\nimport (\n\tt \"time\"\n\n\t\"example.com/app/model\"\n)\n\ntype Cell struct{}\n\ntype Table struct {\n\tRow func(at t.Time, item model.Item, cells []*Cell, page model.Page[Cell])\n}\n",
+		"model/item.go": "package model\n\ntype Item struct{}\n\ntype Page[T any] struct{}\n",
+	} {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(filename, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parse := func(t *testing.T, imports string) *ego.Template {
+		src := "<% package app\nimport (\n" + imports + ")\nfunc Render(ctx context.Context, w io.Writer) { %><u:Table><ego::Row let:at=\"at\" let:item=\"item\" let:cells=\"cells\" let:page=\"page\"></ego::Row></u:Table><% } %>"
+		tmpl, err := ego.Parse(strings.NewReader(src), filepath.Join(dir, "tmpl.ego"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		tmpl := parse(t, "\"time\"\nu \"example.com/app/ui\"\nm \"example.com/app/model\"\n")
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if want := "EGO.Row = func(at time.Time, item m.Item, cells []*u.Cell, page m.Page[u.Cell]) {\n"; !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, buf.String())
+		}
+	})
+
+	t.Run("ErrNotImported", func(t *testing.T) {
+		tmpl := parse(t, "\"time\"\nu \"example.com/app/ui\"\n")
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || !strings.HasSuffix(err.Error(), `Cannot use model.Item: package "example.com/app/model" is not imported by the template`) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure that components are created by their constructor or by pointer if
+// their Render method has a pointer receiver.
+func TestTemplate_WriteTo_Constructor(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "components.go"), []byte(`package foo
+
+type Card struct{ Title string }
+
+func NewCard() *Card { return &Card{Title: "Untitled"} }
+
+func (c *Card) Render(ctx context.Context, w io.Writer) {}
+
+type Box struct{}
+
+func (b *Box) Render(ctx context.Context, w io.Writer) {}
+
+type Link struct{}
+
+func NewLink(href string) Link { return Link{} }
+
+func (l Link) Render(ctx context.Context, w io.Writer) {}
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Panel is only declared by a template.
+	if err := ioutil.WriteFile(filepath.Join(dir, "panel.ego"), []byte("<% package foo\ntype Panel struct{}\nfunc (p *Panel) Render(ctx context.Context, w io.Writer) { %><% } %>"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "tmpl.ego")
+	if err := ioutil.WriteFile(path, []byte("<%# ego:construct %>\n<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Card Title=\"x\" /><ego:Box /><ego:Link /><ego:Panel /><% } %>"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Generate twice with a shared resolver which reads the package once.
+	r := ego.NewResolver()
+	for i := 0; i < 2; i++ {
+		tmpl, err := ego.ParseFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.Resolver = r
+
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"\t\tvar EGO *Card = NewCard()\n",
+			"\t\tvar EGO *Box = &Box{}\n",
+			"\t\tvar EGO Link\n",
+			"\t\tvar EGO *Panel = &Panel{}\n",
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Fatalf("expected %q in output:\n%s", want, buf.String())
+			}
+		}
+	}
+	// Without the option, components are declared by value & their
+	// packages are not read.
+	t.Run("Disabled", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Card /><ego:Missing /><% } %>"), filepath.Join(dir, "tmpl.ego"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := tmpl.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(buf.String(), "\t\tvar EGO Card\n") {
+			t.Fatalf("unexpected output:\n%s", buf.String())
+		}
+	})
+
+	// Types which cannot be found are reported.
+	t.Run("ErrMissingType", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%# ego:construct %>\n<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ego:Missing /><% } %>"), filepath.Join(dir, "tmpl.ego"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "Cannot find type Missing") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Packages which cannot be loaded are reported.
+	t.Run("ErrMissingPackage", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%# ego:construct %>\n<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><ui:Button /><% } %>"), filepath.Join(dir, "tmpl.ego"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.WriteTo(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "Cannot find import for package ui") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,583 @@
+package ego
+
+import (
+	"fmt"
+	"strings"
+)
+
+// state represents the state of the HTML tokenizer at a position in the template.
+type state uint8
+
+const (
+	stateText        state = iota // element content
+	stateTag                      // inside a tag, before an attribute name
+	stateAttrName                 // inside an attribute name
+	stateAfterName                // after an attribute name, before '='
+	stateBeforeValue              // after '=', before an attribute value
+	stateAttr                     // inside an attribute value
+	stateComment                  // inside an HTML comment
+	stateRCDATA                   // inside a <textarea> or <title> element
+	stateScript                   // inside a <script> element
+	stateStyle                    // inside a <style> element
+)
+
+// attrType represents the type of content held by an attribute value.
+type attrType uint8
+
+const (
+	attrNone attrType = iota // plain text
+	attrURL                  // URL, such as href or src
+	attrJS                   // JavaScript, such as onclick
+	attrCSS                  // CSS, such as style
+)
+
+// urlPart represents the position within a URL attribute value.
+type urlPart uint8
+
+const (
+	urlPartNone     urlPart = iota // start of the URL
+	urlPartPreQuery                // after the start, before '?' or '#'
+	urlPartQuery                   // after '?' or '#'
+)
+
+// jsState represents the state of the JavaScript tokenizer within a script
+// element or event handler attribute.
+type jsState uint8
+
+const (
+	jsStateCode           jsState = iota // outside of literals & comments
+	jsStateString                        // inside a string literal
+	jsStateLineComment                   // inside a // comment
+	jsStateBlockComment                  // inside a /* */ comment
+	jsStateRegexp                        // inside a regular expression literal
+	jsStateRegexpClass                   // inside a [...] class of a regular expression
+	jsStateUnknown                       // after a '/' which may be a division or a regular expression
+	jsStateNestedTemplate                // inside a template literal nested in a template literal
+)
+
+// jsSlash represents the meaning of a '/' in JavaScript code, which depends
+// on the preceding token.
+type jsSlash uint8
+
+const (
+	jsSlashRegexp  jsSlash = iota // starts a regular expression
+	jsSlashDiv                    // is a division operator
+	jsSlashUnknown                // may be either
+)
+
+// jsContext represents the JavaScript context at a position in the template.
+type jsContext struct {
+	state jsState
+	quote byte    // open string quote character
+	slash jsSlash // meaning of the next '/' in code
+
+	// Set in the ${...} expression of a template literal. Braces counts the
+	// braces opened in the expression so its closing brace can be found.
+	tmplExpr bool
+	braces   int
+}
+
+// escapeContext represents the HTML context at a position in the template.
+// It is advanced over the content of text blocks so that print blocks can be
+// escaped according to where they appear in the document.
+type escapeContext struct {
+	state    state
+	element  string    // lowercase name of the current element tag
+	attrName string    // lowercase name of the current attribute
+	attr     attrType  // type of the current attribute value
+	delim    byte      // attribute value quote character, zero if unquoted
+	urlPart  urlPart   // position within a URL attribute value
+	js       jsContext // JavaScript context in a script or event handler
+}
+
+// escaper returns the name of the runtime escaping function for the context.
+// Returns a blank string if the value should be HTML escaped.
+func (c escapeContext) escaper() string {
+	switch c.state {
+	case stateAttr:
+		switch c.attr {
+		case attrURL:
+			switch c.urlPart {
+			case urlPartNone:
+				return "EscapeURL"
+			case urlPartPreQuery:
+				return "NormalizeURL"
+			default:
+				return "EscapeURLQuery"
+			}
+		case attrJS:
+			if fn := c.js.escaper(); fn != "EscapeJSValue" {
+				return fn
+			}
+			// Values are not padded with spaces in attributes.
+			return "EscapeJSAttrValue"
+		case attrCSS:
+			return "EscapeCSS"
+		}
+		if c.delim == 0 {
+			return "EscapeUnquotedAttr"
+		}
+		return ""
+
+	case stateScript:
+		return c.js.escaper()
+
+	case stateStyle:
+		return "EscapeCSS"
+
+	default:
+		return ""
+	}
+}
+
+// err returns an error if values cannot be printed safely in the context.
+func (c escapeContext) err() error {
+	if c.state != stateScript && (c.state != stateAttr || c.attr != attrJS) {
+		return nil
+	}
+
+	switch c.js.state {
+	case jsStateUnknown:
+		return fmt.Errorf("Cannot print in JavaScript after a '/' which may start a regular expression, move the value to the next line")
+	case jsStateNestedTemplate:
+		return fmt.Errorf("Cannot print in a nested JavaScript template literal")
+	}
+	return nil
+}
+
+// beforePrint returns the context used for a print block.
+// A value printed directly after an '=' starts an unquoted attribute value.
+func (c escapeContext) beforePrint() escapeContext {
+	if c.state == stateBeforeValue {
+		c.state, c.delim = stateAttr, 0
+	}
+	return c
+}
+
+// afterPrint returns the context following a print block.
+func (c escapeContext) afterPrint() escapeContext {
+	if c.state == stateAttr && c.attr == attrURL && c.urlPart == urlPartNone {
+		c.urlPart = urlPartPreQuery
+	}
+
+	// A value printed in JavaScript code is followed by a division.
+	if (c.state == stateScript || (c.state == stateAttr && c.attr == attrJS)) && c.js.state == jsStateCode {
+		c.js.slash = jsSlashDiv
+	}
+	return c
+}
+
+// advance returns the context after processing s.
+func (c escapeContext) advance(s string) escapeContext {
+	for i := 0; i < len(s); {
+		c, i = c.step(s, i)
+	}
+	return c
+}
+
+// step processes s starting from i and returns the new context and the
+// position in s to continue from.
+func (c escapeContext) step(s string, i int) (escapeContext, int) {
+	switch c.state {
+	case stateText:
+		return c.stepText(s, i)
+	case stateTag:
+		return c.stepTag(s, i)
+	case stateAttrName:
+		return c.stepAttrName(s, i)
+	case stateAfterName:
+		return c.stepAfterName(s, i)
+	case stateBeforeValue:
+		return c.stepBeforeValue(s, i)
+	case stateAttr:
+		return c.stepAttr(s, i)
+	case stateComment:
+		if j := strings.Index(s[i:], "-->"); j >= 0 {
+			c.state = stateText
+			return c, i + j + 3
+		}
+		return c, len(s)
+	default:
+		return c.stepRawText(s, i)
+	}
+}
+
+func (c escapeContext) stepText(s string, i int) (escapeContext, int) {
+	j := strings.IndexByte(s[i:], '<')
+	if j == -1 {
+		return c, len(s)
+	}
+	i += j
+
+	// Start of an HTML comment.
+	if strings.HasPrefix(s[i:], "<!--") {
+		c.state = stateComment
+		return c, i + 4
+	}
+
+	// Start of an opening or closing tag.
+	start, closing := i+1, false
+	if start < len(s) && s[start] == '/' {
+		start, closing = start+1, true
+	}
+	if start >= len(s) || !isASCIILetter(s[start]) {
+		return c, i + 1
+	}
+
+	end := start
+	for end < len(s) && isTagNameChar(s[end]) {
+		end++
+	}
+
+	c.state, c.element = stateTag, strings.ToLower(s[start:end])
+	if closing {
+		c.element = ""
+	}
+	return c, end
+}
+
+func (c escapeContext) stepTag(s string, i int) (escapeContext, int) {
+	for ; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '>':
+			return c.endTag(), i + 1
+		case ch == '/' || isHTMLSpace(ch):
+			continue
+		default:
+			c.state, c.attrName = stateAttrName, ""
+			return c, i
+		}
+	}
+	return c, i
+}
+
+func (c escapeContext) stepAttrName(s string, i int) (escapeContext, int) {
+	start := i
+	for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+		i++
+	}
+	c.attrName += strings.ToLower(s[start:i])
+	if i == len(s) {
+		return c, i
+	}
+
+	switch s[i] {
+	case '=':
+		return c.beginValue(), i + 1
+	case '>', '/':
+		c.state = stateTag
+		return c, i
+	default:
+		c.state = stateAfterName
+		return c, i
+	}
+}
+
+func (c escapeContext) stepAfterName(s string, i int) (escapeContext, int) {
+	for ; i < len(s); i++ {
+		if ch := s[i]; ch == '=' {
+			return c.beginValue(), i + 1
+		} else if !isHTMLSpace(ch) {
+			c.state = stateTag
+			return c, i
+		}
+	}
+	return c, i
+}
+
+func (c escapeContext) stepBeforeValue(s string, i int) (escapeContext, int) {
+	for ; i < len(s); i++ {
+		switch ch := s[i]; {
+		case isHTMLSpace(ch):
+			continue
+		case ch == '>':
+			c.state = stateTag
+			return c, i
+		case ch == '"' || ch == '\'':
+			c.state, c.delim = stateAttr, ch
+			return c, i + 1
+		default:
+			c.state, c.delim = stateAttr, 0
+			return c, i
+		}
+	}
+	return c, i
+}
+
+func (c escapeContext) stepAttr(s string, i int) (escapeContext, int) {
+	// Find the end of the attribute value.
+	end := -1
+	for j := i; j < len(s); j++ {
+		if ch := s[j]; (c.delim != 0 && ch == c.delim) || (c.delim == 0 && (ch == '>' || isHTMLSpace(ch))) {
+			end = j - i
+			break
+		}
+	}
+
+	value := s[i:]
+	if end >= 0 {
+		value = s[i : i+end]
+	}
+
+	// Track the state within the value based on the attribute type.
+	switch c.attr {
+	case attrURL:
+		if strings.ContainsAny(value, "?#") {
+			c.urlPart = urlPartQuery
+		} else if value != "" && c.urlPart == urlPartNone {
+			c.urlPart = urlPartPreQuery
+		}
+	case attrJS:
+		c.js = c.js.advance(value)
+	}
+
+	if end == -1 {
+		return c, len(s)
+	}
+
+	// Closing quotes are consumed while unquoted delimiters are left
+	// for the tag state to process.
+	i += end
+	if c.delim != 0 {
+		i++
+	}
+	c.state, c.attrName, c.attr, c.delim, c.urlPart, c.js = stateTag, "", attrNone, 0, urlPartNone, jsContext{}
+	return c, i
+}
+
+// stepRawText processes the content of elements which cannot contain tags.
+func (c escapeContext) stepRawText(s string, i int) (escapeContext, int) {
+	// Find the closing tag for the current element.
+	end := len(s)
+	for j := i; j < len(s); j++ {
+		if s[j] != '<' || j+1 >= len(s) || s[j+1] != '/' {
+			continue
+		} else if n := j + 2 + len(c.element); n <= len(s) && strings.EqualFold(s[j+2:n], c.element) {
+			end = j
+			break
+		}
+	}
+
+	if c.state == stateScript {
+		c.js = c.js.advance(s[i:end])
+	}
+
+	if end == len(s) {
+		return c, end
+	}
+	i = end + 2 + len(c.element)
+	c.state, c.element, c.js = stateTag, "", jsContext{}
+	return c, i
+}
+
+// endTag returns the context following the '>' of a tag.
+func (c escapeContext) endTag() escapeContext {
+	switch c.element {
+	case "script":
+		c.state = stateScript
+	case "style":
+		c.state = stateStyle
+	case "textarea", "title":
+		c.state = stateRCDATA
+	default:
+		c.state, c.element = stateText, ""
+	}
+	return c
+}
+
+// beginValue returns the context at the start of an attribute value.
+func (c escapeContext) beginValue() escapeContext {
+	c.state, c.attr, c.urlPart, c.js = stateBeforeValue, attrTypeOf(c.attrName), urlPartNone, jsContext{}
+	return c
+}
+
+// attrTypeOf returns the type of content expected by the named attribute.
+func attrTypeOf(name string) attrType {
+	// Strip namespace prefixes such as "xlink:href".
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return attrJS
+	case name == "style":
+		return attrCSS
+	}
+
+	switch name {
+	case "action", "background", "cite", "codebase", "data", "formaction",
+		"href", "icon", "longdesc", "manifest", "poster", "profile", "src", "usemap":
+		return attrURL
+	default:
+		return attrNone
+	}
+}
+
+// escaper returns the name of the runtime escaping function for a value
+// printed in the JavaScript context. Values in strings, comments & regular
+// expressions are escaped so they cannot end them. Values in code are written
+// as JSON values. Contexts where values cannot be printed safely are reported
+// by escapeContext.err.
+func (c jsContext) escaper() string {
+	switch c.state {
+	case jsStateString:
+		if c.quote == '`' {
+			return "EscapeJSTemplate"
+		}
+		return "EscapeJSString"
+	case jsStateLineComment, jsStateBlockComment:
+		return "EscapeJSString"
+	case jsStateRegexp, jsStateRegexpClass:
+		return "EscapeJSRegexp"
+	default:
+		return "EscapeJSValue"
+	}
+}
+
+// advance returns the JavaScript context after processing s.
+func (c jsContext) advance(s string) jsContext {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch c.state {
+		case jsStateString:
+			switch {
+			case ch == '\\':
+				i++
+			case ch == c.quote:
+				c.state, c.quote, c.slash = jsStateCode, 0, jsSlashDiv
+			case c.quote == '`' && ch == '$' && i+1 < len(s) && s[i+1] == '{':
+				// Only one level of template expressions is tracked.
+				if c.tmplExpr {
+					c.state = jsStateNestedTemplate
+					continue
+				}
+				c.state, c.quote, c.slash, c.tmplExpr, c.braces, i = jsStateCode, 0, jsSlashRegexp, true, 0, i+1
+			}
+
+		case jsStateLineComment:
+			if ch == '\n' || ch == '\r' {
+				c.state = jsStateCode
+			}
+
+		case jsStateBlockComment:
+			if ch == '*' && i+1 < len(s) && s[i+1] == '/' {
+				c.state, i = jsStateCode, i+1
+			}
+
+		case jsStateRegexp:
+			switch ch {
+			case '\\':
+				i++
+			case '[':
+				c.state = jsStateRegexpClass
+			case '/':
+				c.state, c.slash = jsStateCode, jsSlashDiv
+			}
+
+		case jsStateRegexpClass:
+			if ch == '\\' {
+				i++
+			} else if ch == ']' {
+				c.state = jsStateRegexp
+			}
+
+		case jsStateNestedTemplate:
+			// The context is not known again until the end of the script.
+
+		case jsStateUnknown:
+			// Regular expressions cannot span lines so the state is
+			// known again at the end of the line.
+			if ch == '\n' || ch == '\r' {
+				c.state, c.slash = jsStateCode, jsSlashRegexp
+			}
+
+		default:
+			switch {
+			case ch == '"' || ch == '\'' || ch == '`':
+				c.state, c.quote = jsStateString, ch
+			case ch == '{' && c.tmplExpr:
+				c.braces, c.slash = c.braces+1, jsSlashRegexp
+			case ch == '}' && c.tmplExpr && c.braces == 0:
+				// End of a template expression.
+				c.state, c.quote, c.tmplExpr = jsStateString, '`', false
+			case ch == '}' && c.tmplExpr:
+				c.braces, c.slash = c.braces-1, jsSlashRegexp
+			case ch == '/' && i+1 < len(s) && s[i+1] == '/':
+				c.state, i = jsStateLineComment, i+1
+			case ch == '/' && i+1 < len(s) && s[i+1] == '*':
+				c.state, i = jsStateBlockComment, i+1
+			case ch == '/' && c.slash == jsSlashRegexp:
+				c.state = jsStateRegexp
+			case ch == '/' && c.slash == jsSlashUnknown:
+				c.state = jsStateUnknown
+			case ch == '/':
+				c.slash = jsSlashRegexp
+			case !isHTMLSpace(ch):
+				c.slash = nextJSSlash(s[:i+1])
+			}
+		}
+	}
+	return c
+}
+
+// nextJSSlash returns the meaning of a '/' following the code in s, which
+// ends with a token that is not whitespace. This is a heuristic based on the
+// last token similar to the one used by html/template.
+func nextJSSlash(s string) jsSlash {
+	switch ch := s[len(s)-1]; ch {
+	case '+', '-':
+		// Increment & decrement operators are followed by a division.
+		if len(s) > 1 && s[len(s)-2] == ch {
+			return jsSlashDiv
+		}
+		return jsSlashRegexp
+	case '.':
+		// A number such as "42." is followed by a division.
+		if len(s) > 1 && s[len(s)-2] >= '0' && s[len(s)-2] <= '9' {
+			return jsSlashDiv
+		}
+		return jsSlashRegexp
+	case ',', '<', '>', '=', '*', '%', '&', '|', '^', '?', '!', '~', '(', '[', ':', ';', '{', '}':
+		return jsSlashRegexp
+	case ')':
+		// A parenthesized expression is usually followed by a division
+		// but the condition of a statement is followed by an expression,
+		// as in "if (b) /re/.test(s)".
+		return jsSlashUnknown
+	}
+
+	// Keywords which precede an expression are followed by a regular
+	// expression. Other identifiers & numbers are followed by a division.
+	i := len(s)
+	for i > 0 && isJSIdentChar(s[i-1]) {
+		i--
+	}
+	if jsRegexpPrecederKeywords[s[i:]] {
+		return jsSlashRegexp
+	}
+	return jsSlashDiv
+}
+
+// jsRegexpPrecederKeywords are keywords which can precede a regular expression.
+var jsRegexpPrecederKeywords = map[string]bool{
+	"break": true, "case": true, "continue": true, "delete": true, "do": true,
+	"else": true, "finally": true, "in": true, "instanceof": true, "return": true,
+	"throw": true, "try": true, "typeof": true, "void": true,
+}
+
+func isJSIdentChar(ch byte) bool {
+	return isASCIILetter(ch) || (ch >= '0' && ch <= '9') || ch == '_' || ch == '$' || ch >= 0x80
+}
+
+func isASCIILetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isTagNameChar(ch byte) bool {
+	return isASCIILetter(ch) || (ch >= '0' && ch <= '9') || ch == '-' || ch == ':'
+}
+
+func isHTMLSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
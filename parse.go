@@ -3,6 +3,7 @@ package ego
 import (
 	"io"
 	"os"
+	"strings"
 )
 
 // ParseFile parses an Ego template from a file.
@@ -17,72 +18,134 @@ func ParseFile(path string) (*Template, error) {
 
 // Parse parses an Ego template from a reader.
 // The path specifies the path name used in the compiled template's pragmas.
+//
+// Parsing continues after a syntax error so that all errors in the template
+// are reported. In that case, an ErrorList is returned along with a partial
+// template containing the blocks that could be parsed.
 func Parse(r io.Reader, path string) (*Template, error) {
-	s := NewScanner(r, path)
+	p := &blockParser{s: NewScanner(r, path)}
 	t := &Template{Path: path}
 	for {
-		blk, err := s.Scan()
+		blk, err := p.scan()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
+		} else if blk == nil {
+			continue
 		}
 
 		switch blk := blk.(type) {
 		case *ComponentStartBlock:
-			if err := parseComponentBlock(s, blk); err != nil {
+			if err := p.parseComponentBlock(blk); err != nil {
 				return nil, err
 			}
 		case *ComponentEndBlock:
-			return nil, NewSyntaxError(blk.Pos, "Component end block found without matching start block: %s", shortComponentBlockString(blk))
+			p.errorf(blk.Pos, "Component end block found without matching start block: %s", shortComponentBlockString(blk))
+			continue
 		case *AttrStartBlock:
-			return nil, NewSyntaxError(blk.Pos, "Attribute start block found outside of component: %s", shortComponentBlockString(blk))
+			p.errorf(blk.Pos, "Attribute start block found outside of component: %s", shortComponentBlockString(blk))
+			continue
 		case *AttrEndBlock:
-			return nil, NewSyntaxError(blk.Pos, "Attribute end block found outside of component: %s", shortComponentBlockString(blk))
+			p.errorf(blk.Pos, "Attribute end block found outside of component: %s", shortComponentBlockString(blk))
+			continue
+		case *CommentBlock:
+			p.parsePragma(blk, &t.Options)
 		}
 
 		t.Blocks = append(t.Blocks, blk)
 	}
 	t.Blocks = normalizeBlocks(t.Blocks)
-	return t, nil
+	return t, p.errs.Err()
 }
 
-func parseComponentBlock(s *Scanner, start *ComponentStartBlock) error {
+// blockParser builds a template from the blocks returned by a scanner.
+// Syntax errors are collected so that parsing can continue.
+type blockParser struct {
+	s    *Scanner
+	errs ErrorList
+
+	// Block returned by the next call to scan, if set.
+	unread Block
+}
+
+// scan returns the next block from the scanner. On a syntax error, the error
+// is recorded and a nil block is returned. Scanning resumes at the next block
+// after the start of the invalid block since the scanner may have read past
+// the end of it. Other errors, such as read errors, are returned.
+func (p *blockParser) scan() (Block, error) {
+	if blk := p.unread; blk != nil {
+		p.unread = nil
+		return blk, nil
+	}
+
+	i, pos := p.s.i, p.s.pos
+	blk, err := p.s.Scan()
+	if e, ok := err.(*SyntaxError); ok {
+		p.errs = append(p.errs, e)
+		p.s.i, p.s.pos = i, pos
+		p.s.read()
+		p.s.skipToNextBlock()
+		return nil, nil
+	}
+	return blk, err
+}
+
+// errorf records a syntax error.
+func (p *blockParser) errorf(pos Pos, format string, args ...interface{}) {
+	p.errs = append(p.errs, NewSyntaxError(pos, format, args...))
+}
+
+func (p *blockParser) parseComponentBlock(start *ComponentStartBlock) error {
 	if start.Closed {
 		start.Yield = normalizeBlocks(start.Yield)
 		return nil
 	}
 
+	// Text blocks are not joined across attribute blocks so that the
+	// order of the blocks can be recovered from their positions.
+	var n int
+	normalize := func() {
+		start.Yield = trimTrailingEmptyTextBlocks(append(start.Yield[:n], joinAdjacentTextBlocks(start.Yield[n:])...))
+	}
+
 	for {
-		blk, err := s.Scan()
+		blk, err := p.scan()
 		if err == io.EOF {
-			return NewSyntaxError(start.Pos, "Expected component close tag, found EOF: %s", shortComponentBlockString(start))
+			p.errorf(start.Pos, "Expected component close tag, found EOF: %s", shortComponentBlockString(start))
+			normalize()
+			return nil
 		} else if err != nil {
 			return err
 		}
 
 		switch blk := blk.(type) {
+		case nil:
+			continue
+
 		case *ComponentStartBlock:
-			if err := parseComponentBlock(s, blk); err != nil {
+			if err := p.parseComponentBlock(blk); err != nil {
 				return err
 			}
 			start.Yield = append(start.Yield, blk)
 
 		case *ComponentEndBlock:
 			if blk.Name != start.Name {
-				return NewSyntaxError(blk.Pos, "Component end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(blk))
+				p.errorf(blk.Pos, "Component end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(blk))
 			}
-			start.Yield = normalizeBlocks(start.Yield)
+			normalize()
 			return nil
 
 		case *AttrStartBlock:
-			if err := parseAttrBlock(s, blk); err != nil {
+			if err := p.parseAttrBlock(blk); err != nil {
 				return err
 			}
 			start.AttrBlocks = append(start.AttrBlocks, blk)
+			start.Yield = append(start.Yield[:n], joinAdjacentTextBlocks(start.Yield[n:])...)
+			n = len(start.Yield)
 
 		case *AttrEndBlock:
-			return NewSyntaxError(blk.Pos, "Attribute end block found without start block: %s", shortComponentBlockString(blk))
+			p.errorf(blk.Pos, "Attribute end block found without start block: %s", shortComponentBlockString(blk))
 
 		default:
 			start.Yield = append(start.Yield, blk)
@@ -90,31 +153,42 @@ func parseComponentBlock(s *Scanner, start *ComponentStartBlock) error {
 	}
 }
 
-func parseAttrBlock(s *Scanner, start *AttrStartBlock) error {
+func (p *blockParser) parseAttrBlock(start *AttrStartBlock) error {
 	for {
-		blk, err := s.Scan()
+		blk, err := p.scan()
 		if err == io.EOF {
-			return NewSyntaxError(start.Pos, "Expected attribute close tag, found EOF: %s", shortComponentBlockString(start))
+			p.errorf(start.Pos, "Expected attribute close tag, found EOF: %s", shortComponentBlockString(start))
+			start.Yield = normalizeBlocks(start.Yield)
+			return nil
 		} else if err != nil {
 			return err
 		}
 
 		switch blk := blk.(type) {
+		case nil:
+			continue
+
 		case *ComponentStartBlock:
-			if err := parseComponentBlock(s, blk); err != nil {
+			if err := p.parseComponentBlock(blk); err != nil {
 				return err
 			}
 			start.Yield = append(start.Yield, blk)
 
+		// Treat the attribute block as closed so the component
+		// can process the block.
 		case *ComponentEndBlock:
-			return NewSyntaxError(blk.Pos, "Expected attribute close block, found %s", shortComponentBlockString(blk))
+			p.errorf(blk.Pos, "Expected attribute close block, found %s", shortComponentBlockString(blk))
+			p.unread, start.Yield = blk, normalizeBlocks(start.Yield)
+			return nil
 
 		case *AttrStartBlock:
-			return NewSyntaxError(blk.Pos, "Attribute block found within attribute block: %s", shortComponentBlockString(blk))
+			p.errorf(blk.Pos, "Attribute block found within attribute block: %s", shortComponentBlockString(blk))
+			p.unread, start.Yield = blk, normalizeBlocks(start.Yield)
+			return nil
 
 		case *AttrEndBlock:
 			if blk.Name != start.Name {
-				return NewSyntaxError(blk.Pos, "Attribute end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(blk))
+				p.errorf(blk.Pos, "Attribute end block mismatch: %s != %s", shortComponentBlockString(start), shortComponentBlockString(blk))
 			}
 			start.Yield = normalizeBlocks(start.Yield)
 			return nil
@@ -124,3 +198,34 @@ func parseAttrBlock(s *Scanner, start *AttrStartBlock) error {
 		}
 	}
 }
+
+// pragmaPrefix is the prefix of each option name in a pragma comment.
+const pragmaPrefix = "ego:"
+
+// pragmas are the names of the options that can be set by a pragma comment.
+var pragmas = map[string]func(opts *Options){
+	pragmaPrefix + "errors":     func(opts *Options) { opts.ReturnErrors = true },
+	pragmaPrefix + "context":    func(opts *Options) { opts.CheckContext = true },
+	pragmaPrefix + "static":     func(opts *Options) { opts.StaticText = true },
+	pragmaPrefix + "typedattrs": func(opts *Options) { opts.TypedAttrs = true },
+	pragmaPrefix + "construct":  func(opts *Options) { opts.Construct = true },
+}
+
+// parsePragma sets the options listed in a pragma comment, such as
+// "<%# ego:errors %>". A comment is a pragma if its first word is the name of
+// an option, in which case every word must be one. Other comments are
+// ignored, even if they mention names with the pragma prefix.
+func (p *blockParser) parsePragma(blk *CommentBlock, opts *Options) {
+	names := strings.Fields(blk.Content)
+	if len(names) == 0 || pragmas[names[0]] == nil {
+		return
+	}
+
+	for _, name := range names {
+		if set := pragmas[name]; set != nil {
+			set(opts)
+		} else {
+			p.errorf(blk.Pos, "Unknown pragma: %s", name)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ego_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that parsing continues after a syntax error and reports all errors.
+func TestParse_ErrorList(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<p>\n</ego:A>\n<ego:B Foo=>\n<%= x %>\n<ego:C></ego:D>\n</ego::E>"), "tmpl.ego")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	errs, ok := err.(ego.ErrorList)
+	if !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+
+	var a []string
+	for _, e := range errs {
+		a = append(a, e.Error())
+	}
+	if got, want := strings.Join(a, "\n"), strings.Join([]string{
+		"tmpl.ego:2:1: Component end block found without matching start block: </ego:A>",
+		"tmpl.ego:3:12: Incomplete Go expression before EOF",
+		"tmpl.ego:5:8: Component end block mismatch: <ego:C> != </ego:D>",
+		"tmpl.ego:6:1: Attribute end block found outside of component: </ego::E>",
+	}, "\n"); got != want {
+		t.Fatalf("unexpected errors:\n%s", got)
+	}
+
+	if got, want := err.Error(), "tmpl.ego:2:1: Component end block found without matching start block: </ego:A> (and 3 more errors)"; got != want {
+		t.Fatalf("unexpected error string: %s", got)
+	}
+
+	// Ensure the blocks after the errors are still parsed.
+	var found bool
+	for _, blk := range tmpl.Blocks {
+		if blk, ok := blk.(*ego.PrintBlock); ok && blk.Content == " x " {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected print block in partial template: %#v", tmpl.Blocks)
+	}
+}
+
+// Ensure that pragma comments set template options.
+func TestParse_Pragma(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%# ego:errors %><%# not a pragma %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if !tmpl.Options.ReturnErrors {
+			t.Fatal("expected ReturnErrors option")
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := ego.Parse(strings.NewReader("<%# ego:context ego:foo %>"), "tmpl.ego")
+		if err == nil || err.Error() != `tmpl.ego:1:1: Unknown pragma: ego:foo` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Comment", func(t *testing.T) {
+		tmpl, err := ego.Parse(strings.NewReader("<%# ego:Card %><%# see ego:errors %>"), "tmpl.ego")
+		if err != nil {
+			t.Fatal(err)
+		} else if tmpl.Options.ReturnErrors {
+			t.Fatal("unexpected ReturnErrors option")
+		}
+	})
+}
@@ -0,0 +1,450 @@
+package ego
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fprint writes the template to w as an ego template in canonical form.
+//
+// Go code in code blocks is formatted with gofmt, print blocks have a single
+// space inside their delimiters, and component tags on their own line are
+// indented with tabs one level deeper than their parent component. Text is
+// written unchanged other than the indentation of component tags & the end of
+// the template, which always ends with a single newline.
+func Fprint(w io.Writer, t *Template) error {
+	var p templatePrinter
+	p.printBlocks(t.Blocks, "", false)
+
+	// Trailing blank text is not kept by the parser.
+	b := bytes.TrimRight(p.buf.Bytes(), "\r\n")
+	if len(b) > 0 {
+		b = append(b, '\n')
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// templatePrinter writes blocks as ego template source.
+type templatePrinter struct {
+	buf bytes.Buffer
+}
+
+// printBlocks writes blocks. If nested is set, component tags on their own
+// line are indented by indent.
+func (p *templatePrinter) printBlocks(blks []Block, indent string, nested bool) {
+	for _, blk := range blks {
+		switch blk := blk.(type) {
+		case *TextBlock:
+			p.buf.WriteString(escapeText(blk.Content))
+
+		case *CodeBlock:
+			p.printCode(blk.Content, blk.TrimLeft, blk.TrimRight)
+
+		case *CommentBlock:
+			p.buf.WriteString("<%#" + blk.Content + "%>")
+
+		case *PrintBlock:
+			p.printDelims("<%"+trimMarker(blk.TrimLeft)+"= ", formatExpr(blk.Content), " "+trimMarker(blk.TrimRight)+"%>")
+
+		case *RawPrintBlock:
+			p.printDelims("<%"+trimMarker(blk.TrimLeft)+"== ", formatExpr(blk.Content), " "+trimMarker(blk.TrimRight)+"%>")
+
+		case *ComponentStartBlock:
+			p.printComponent(blk, indent, nested)
+		}
+	}
+}
+
+func (p *templatePrinter) printCode(code string, trimLeft, trimRight bool) {
+	code = formatCode(code)
+
+	// Empty code blocks have a single space.
+	if code == "" {
+		p.buf.WriteString("<%" + trimMarker(trimLeft) + " " + trimMarker(trimRight) + "%>")
+		return
+	}
+
+	// Multi-line code starts & ends on separate lines from the delimiters.
+	// Code ending with a line comment is written the same way so the
+	// comment does not contain the close delimiter.
+	if strings.Contains(code, "\n") || endsWithLineComment(code) {
+		p.printDelims("<%"+trimMarker(trimLeft)+"\n", code, "\n"+trimMarker(trimRight)+"%>")
+		return
+	}
+	p.printDelims("<%"+trimMarker(trimLeft)+" ", code, " "+trimMarker(trimRight)+"%>")
+}
+
+// printDelims writes Go code between delimiters.
+func (p *templatePrinter) printDelims(open, code, close string) {
+	p.buf.WriteString(open)
+	p.buf.WriteString(escapeCode(code))
+	p.buf.WriteString(close)
+}
+
+func (p *templatePrinter) printComponent(blk *ComponentStartBlock, indent string, nested bool) {
+	indent = p.indentLine(indent, nested)
+
+	// Components are indented with tabs so nested tags can be indented
+	// one level deeper.
+	if strings.Contains(indent, " ") {
+		indent = p.indentLine(tabIndent(indent), true)
+	}
+
+	// Write start tag with fields & attributes in their original order.
+	p.buf.WriteString("<" + blk.Namespace() + ":" + blk.Name)
+	for _, field := range sortedFields(blk) {
+		p.buf.WriteString(" " + field.Name)
+		if field.ValuePos != (Pos{}) || field.Value != "true" {
+			p.buf.WriteString("=" + field.Value)
+		}
+		if field.Cond != "" {
+			p.buf.WriteString(" " + condAttrName + "=" + field.Cond)
+		}
+	}
+	if blk.Closed {
+		p.buf.WriteString(" />")
+		return
+	}
+	p.buf.WriteString(">")
+	start := p.buf.Len()
+
+	// Write attribute blocks & content in their original order.
+	for _, child := range componentChildren(blk) {
+		if attrBlock, ok := child.(*AttrStartBlock); ok {
+			attrIndent := p.indentLine(indent+"\t", true)
+			p.buf.WriteString("<" + attrBlock.Namespace() + "::" + attrBlock.Name)
+			for _, let := range attrBlock.Lets {
+				p.buf.WriteString(" " + letAttrPrefix + let.Name + "=" + strconv.Quote(let.Value))
+			}
+			p.buf.WriteString(">")
+			p.printBlocks(attrBlock.Yield, attrIndent+"\t", true)
+			p.indentLine(attrIndent, true)
+			p.buf.WriteString("</" + attrBlock.Namespace() + "::" + attrBlock.Name + ">")
+			continue
+		}
+		p.printBlocks([]Block{child}, indent+"\t", true)
+	}
+
+	// Trailing whitespace is not part of the content so the end tag of a
+	// multi-line component can always be written on its own line.
+	if bytes.Contains(p.buf.Bytes()[start:], []byte("\n")) {
+		p.newline()
+	}
+	p.indentLine(indent, true)
+	p.buf.WriteString("</" + blk.Namespace() + ":" + blk.Name + ">")
+}
+
+// tabIndent returns indentation of the same width as indent using only tabs.
+// Tabs are four columns wide & partial tabs are rounded up.
+func tabIndent(indent string) string {
+	var width int
+	for _, ch := range indent {
+		if ch == '\t' {
+			width += 4
+		} else {
+			width++
+		}
+	}
+	return strings.Repeat("\t", (width+3)/4)
+}
+
+// newline starts a new line unless the current line only contains whitespace.
+func (p *templatePrinter) newline() {
+	b := p.buf.Bytes()
+	if strings.TrimLeft(string(b[bytes.LastIndexByte(b, '\n')+1:]), " \t") != "" {
+		p.buf.WriteString("\n")
+	}
+}
+
+// indentLine replaces the indentation of the current line with indent if
+// the line only contains whitespace and force is set. Returns the
+// indentation of the current line.
+func (p *templatePrinter) indentLine(indent string, force bool) string {
+	b := p.buf.Bytes()
+	start := bytes.LastIndexByte(b, '\n') + 1
+	line := string(b[start:])
+	if strings.TrimLeft(line, " \t") != "" {
+		return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	} else if !force {
+		return line
+	}
+
+	p.buf.Truncate(start)
+	p.buf.WriteString(indent)
+	return indent
+}
+
+// fieldOrAttr represents a component field or attribute when printing.
+type fieldOrAttr struct {
+	Name     string
+	NamePos  Pos
+	Value    string
+	ValuePos Pos
+	Cond     string
+	CondPos  Pos
+}
+
+// sortedFields returns the fields, attributes, spreads, & lets of a component in
+// the order they appear in the template.
+func sortedFields(blk *ComponentStartBlock) []fieldOrAttr {
+	var a []fieldOrAttr
+	for _, field := range blk.Fields {
+		a = append(a, fieldOrAttr(*field))
+	}
+	for _, attr := range blk.Attrs {
+		// Attributes without a value are printed by name only.
+		value := attr.Value
+		if attr.ValuePos == (Pos{}) && value == "" {
+			value = "true"
+		}
+		a = append(a, fieldOrAttr{Name: attr.Name, NamePos: attr.NamePos, Value: value, ValuePos: attr.ValuePos, Cond: attr.Cond, CondPos: attr.CondPos})
+	}
+	for _, spread := range blk.Spreads {
+		a = append(a, fieldOrAttr{Name: spreadAttrName, NamePos: spread.NamePos, Value: spread.Value, ValuePos: spread.ValuePos})
+	}
+	for _, let := range blk.Lets {
+		a = append(a, fieldOrAttr{Name: letAttrPrefix + let.Name, NamePos: let.NamePos, Value: strconv.Quote(let.Value), ValuePos: let.ValuePos})
+	}
+	sort.SliceStable(a, func(i, j int) bool { return a[i].NamePos.Offset < a[j].NamePos.Offset })
+	return a
+}
+
+// componentChildren returns the content & attribute blocks of a component
+// in the order they appear in the template.
+func componentChildren(blk *ComponentStartBlock) []Block {
+	a := append([]Block{}, blk.Yield...)
+	for _, attrBlock := range blk.AttrBlocks {
+		a = append(a, attrBlock)
+	}
+	sort.SliceStable(a, func(i, j int) bool { return Position(a[i]).Offset < Position(a[j]).Offset })
+	return a
+}
+
+// escapeText escapes delimiters in text so they are not scanned as blocks.
+func escapeText(s string) string {
+	s = strings.Replace(s, "<%", "<%%", -1)
+	return strings.Replace(s, "%>", "%%>", -1)
+}
+
+// endsWithLineComment returns true if the last token of code is a // comment.
+func endsWithLineComment(code string) bool {
+	var s scanner.Scanner
+	src := []byte(code)
+	s.Init(token.NewFileSet().AddFile("", -1, len(src)), src, nil, scanner.ScanComments)
+
+	var last string
+	for {
+		_, tok, lit := s.Scan()
+		switch {
+		case tok == token.EOF:
+			return strings.HasPrefix(last, "//")
+		case tok == token.SEMICOLON && lit == "\n":
+			continue // automatically inserted
+		}
+		last = lit
+		if tok != token.COMMENT {
+			last = ""
+		}
+	}
+}
+
+// escapeCode escapes close delimiters in Go code so they do not end the
+// block. A "%>" inside a string, rune, or block comment does not end a block
+// so it is left unchanged, as the scanner does not unescape it there.
+func escapeCode(code string) string {
+	var buf strings.Builder
+	var quote byte     // open string or rune quote character
+	var comment string // open comment, either "//" or "/*"
+	for i := 0; i < len(code); i++ {
+		ch := code[i]
+		switch {
+		case comment == "//":
+			if ch == '\n' {
+				comment = ""
+			} else if ch == '%' && i+1 < len(code) && code[i+1] == '>' {
+				buf.WriteString("%%")
+				continue
+			}
+
+		case comment == "/*":
+			if ch == '*' && i+1 < len(code) && code[i+1] == '/' {
+				buf.WriteByte(ch)
+				i, ch, comment = i+1, '/', ""
+			}
+
+		case quote != 0:
+			if ch == '\\' && quote != '`' && i+1 < len(code) {
+				buf.WriteByte(ch)
+				i, ch = i+1, code[i+1]
+			} else if ch == quote {
+				quote = 0
+			}
+
+		case ch == '"' || ch == '`' || ch == '\'':
+			quote = ch
+
+		case ch == '/' && i+1 < len(code) && (code[i+1] == '/' || code[i+1] == '*'):
+			comment = code[i : i+2]
+			buf.WriteByte(ch)
+			i, ch = i+1, code[i+1]
+
+		case ch == '%' && i+1 < len(code) && code[i+1] == '>':
+			buf.WriteString("%%")
+			continue
+		}
+		buf.WriteByte(ch)
+	}
+	return buf.String()
+}
+
+func trimMarker(trim bool) string {
+	if trim {
+		return "-"
+	}
+	return ""
+}
+
+// formatExpr formats a Go expression. Returns the expression with
+// surrounding whitespace removed if it cannot be formatted.
+func formatExpr(expr string) string {
+	expr = strings.TrimSpace(expr)
+
+	// Comments are not retained by the expression parser.
+	if strings.Contains(expr, "//") || strings.Contains(expr, "/*") {
+		return expr
+	}
+
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return expr
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), e); err != nil {
+		return expr
+	}
+	return buf.String()
+}
+
+// Markers used to find formatted code within its surrounding statements.
+const (
+	beginCodeMarker = "//ego:begin"
+	endCodeMarker   = "//ego:end"
+)
+
+// formatCode formats the Go code of a code block. Code blocks usually
+// contain fragments, such as the start of a for loop, so unmatched braces are
+// completed before formatting. Returns the code with surrounding whitespace
+// removed if it cannot be formatted.
+func formatCode(code string) string {
+	code = strings.TrimSpace(code)
+	closes, opens := unmatchedBraces(code)
+
+	// The first block of a template usually contains the package clause,
+	// imports, and the start of the render function.
+	if strings.HasPrefix(code, "package") {
+		if closes > 0 {
+			return code
+		}
+		out, err := format.Source([]byte(code + strings.Repeat("\n}", opens)))
+		if err != nil {
+			return code
+		}
+		lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+		return strings.Join(lines[:len(lines)-opens], "\n")
+	}
+
+	// Otherwise format the code as statements within a function. Unmatched
+	// closing braces are completed with an if statement so "} else {" can
+	// be formatted. Case clauses need an enclosing switch statement.
+	for _, wrap := range []string{"", "switch {\n"} {
+		var buf bytes.Buffer
+		buf.WriteString("package p\nfunc _() {\n" + wrap)
+		buf.WriteString(strings.Repeat("if _ {\n", closes))
+		buf.WriteString(beginCodeMarker + "\n" + code + "\n" + endCodeMarker + "\n")
+		buf.WriteString(strings.Repeat("}\n", opens))
+		if wrap != "" {
+			buf.WriteString("}\n")
+		}
+		buf.WriteString("}\n")
+
+		if out, err := format.Source(buf.Bytes()); err == nil {
+			if s, ok := extractCode(string(out)); ok {
+				return s
+			}
+		}
+	}
+	return code
+}
+
+// extractCode returns the lines between the code markers without their
+// common indentation.
+func extractCode(s string) (string, bool) {
+	var lines []string
+	var inCode bool
+	for _, line := range strings.Split(s, "\n") {
+		switch strings.TrimSpace(line) {
+		case beginCodeMarker:
+			inCode = true
+			continue
+		case endCodeMarker:
+			inCode = false
+			continue
+		}
+		if inCode {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+
+	// Determine common indentation of non-blank lines.
+	indent := -1
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if n := len(line) - len(strings.TrimLeft(line, "\t")); indent == -1 || n < indent {
+			indent = n
+		}
+	}
+
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = line[indent:]
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// unmatchedBraces returns the number of closing braces without an opening
+// brace & the number of opening braces without a closing brace.
+func unmatchedBraces(code string) (closes, opens int) {
+	var s scanner.Scanner
+	src := []byte(code)
+	s.Init(token.NewFileSet().AddFile("", -1, len(src)), src, nil, 0)
+	for {
+		_, tok, _ := s.Scan()
+		switch tok {
+		case token.EOF:
+			return closes, opens
+		case token.LBRACE:
+			opens++
+		case token.RBRACE:
+			if opens > 0 {
+				opens--
+			} else {
+				closes++
+			}
+		}
+	}
+}
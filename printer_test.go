@@ -0,0 +1,109 @@
+package ego_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that templates are printed in canonical form.
+func TestFprint(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Header",
+			src:  "<%\npackage foo\nimport \"strings\"\nfunc Render(ctx context.Context,w io.Writer) {\n%>\n<% } %>",
+			want: "<%\npackage foo\n\nimport \"strings\"\n\nfunc Render(ctx context.Context, w io.Writer) {\n%>\n<% } %>\n",
+		},
+		{
+			name: "CodeBlock",
+			src:  "<%for _,x:=range xs{%>a<%}else{%>b<%}%>",
+			want: "<% for _, x := range xs { %>a<% } else { %>b<% } %>\n",
+		},
+		{
+			name: "CaseClause",
+			src:  "<%switch x{%><%case 1,2:%>a<%}%>",
+			want: "<% switch x { %><% case 1, 2: %>a<% } %>\n",
+		},
+		{
+			name: "MultilineCodeBlock",
+			src:  "<%\n  x:=1\n  if x>0 {\n%>",
+			want: "<%\nx := 1\nif x > 0 {\n%>\n",
+		},
+		{
+			name: "InvalidCodeBlock",
+			src:  "<%  x :=  %>",
+			want: "<% x := %>\n",
+		},
+		{
+			name: "PrintBlock",
+			src:  "<%=x+1%> <%==  strings.ToUpper( x )  -%> <%-= y%>",
+			want: "<%= x + 1 %> <%== strings.ToUpper(x) -%> <%-= y %>\n",
+		},
+		{
+			name: "CloseDelimInString",
+			src:  "<% x := \"%>\" + `%>` // %%>\n%><%= '%' %>",
+			want: "<%\nx := \"%>\" + `%>` // %%>\n%><%= '%' %>\n",
+		},
+		{
+			name: "TrailingText",
+			src:  "<p><% y() %>\n\n",
+			want: "<p><% y() %>\n",
+		},
+		{
+			name: "Text",
+			src:  "<p>100<%% done %%></p><%# comment %>",
+			want: "<p>100<%% done %%></p><%# comment %>\n",
+		},
+		{
+			name: "Spread",
+			src:  "<ego:Card  ego:attrs=r.Attrs   class=\"c\" />",
+			want: "<ego:Card ego:attrs=r.Attrs class=\"c\" />\n",
+		},
+		{
+			name: "Cond",
+			src:  "<ego:Link Href=u  ego:if=ok class=\"c\" ego:if=(n > 0) />",
+			want: "<ego:Link Href=u ego:if=ok class=\"c\" ego:if=(n > 0) />\n",
+		},
+		{
+			name: "Let",
+			src:  "<ego:Table Items=items let:total=n><ego::Row  let:item=\"row\" let:i=idx><%= row %></ego::Row></ego:Table>",
+			want: "<ego:Table Items=items let:total=\"n\"><ego::Row let:item=\"row\" let:i=\"idx\"><%= row %></ego::Row></ego:Table>\n",
+		},
+		{
+			name: "Component",
+			src:  "<div>\n  <ego:Card Title=\"x\" class=\"c\"  Count=3 disabled>\n<ego::Header>\n<b>Hi</b>\n      </ego::Header>\n body\n      <ego:Inner/>\n        </ego:Card>\n</div>",
+			want: "<div>\n\t<ego:Card Title=\"x\" class=\"c\" Count=3 disabled>\n\t\t<ego::Header>\n<b>Hi</b>\n\t\t</ego::Header>\n body\n\t\t<ego:Inner />\n\t</ego:Card>\n</div>\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ego.Parse(strings.NewReader(tt.src), "tmpl.ego")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := ego.Fprint(&buf, tmpl); err != nil {
+				t.Fatal(err)
+			} else if got := buf.String(); got != tt.want {
+				t.Fatalf("unexpected output:\n%s", got)
+			}
+
+			// Ensure that formatting the output does not change it.
+			if tmpl, err = ego.Parse(strings.NewReader(buf.String()), "tmpl.ego"); err != nil {
+				t.Fatal(err)
+			}
+			var other bytes.Buffer
+			if err := ego.Fprint(&other, tmpl); err != nil {
+				t.Fatal(err)
+			} else if other.String() != buf.String() {
+				t.Fatalf("output not stable:\n%s", other.String())
+			}
+		})
+	}
+}
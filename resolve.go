@@ -0,0 +1,409 @@
+package ego
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Resolver reads the declarations of the packages used by components.
+// Packages are cached so templates generated with the same Resolver read each
+// package once. Changes to files after a package is read are not noticed, so
+// a Resolver should only be used for one pass over a set of templates.
+type Resolver struct {
+	pkgs map[string]*packageDecls // by directory
+	dirs map[string]string        // package directories by import path & source directory
+}
+
+// NewResolver returns a new instance of Resolver.
+func NewResolver() *Resolver {
+	return &Resolver{
+		pkgs: make(map[string]*packageDecls),
+		dirs: make(map[string]string),
+	}
+}
+
+// typeResolver finds the declarations of component types. Types in the
+// template's package are found in the Go files & templates in the template's
+// directory. Types in other packages are found through the template's
+// imports. Packages are only loaded when a declaration is needed.
+type typeResolver struct {
+	t *Template
+	r *Resolver
+
+	imports map[string]string // import paths by package name
+}
+
+func newTypeResolver(t *Template) *typeResolver {
+	r := t.Resolver
+	if r == nil {
+		r = NewResolver()
+	}
+	return &typeResolver{t: t, r: r}
+}
+
+// packageDecls holds the top-level declarations of a package.
+type packageDecls struct {
+	name    string
+	fset    *token.FileSet
+	types   map[string]ast.Expr      // type expressions by type name
+	funcs   map[string]*ast.FuncDecl // functions by name
+	methods map[string]*ast.FuncDecl // methods by "Type.Method"
+
+	// Import paths by package name of the file declaring each type.
+	imports map[string]map[string]string
+
+	// Templates which have not been read. They are only generated when a
+	// declaration is not found in the Go files of the package.
+	templates []string
+}
+
+// funcField returns the type of a func field of a component type along with
+// the package declaring the component.
+func (r *typeResolver) funcField(blk *ComponentStartBlock, name string) (*ast.FuncType, *packageDecls, error) {
+	pkg, err := r.packageOf(blk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, ok := pkg.typeOf(blk.Name).(*ast.StructType)
+	if !ok {
+		return nil, nil, fmt.Errorf("Cannot find struct type %s", blk.qualifiedName())
+	}
+	for _, field := range st.Fields.List {
+		for _, ident := range field.Names {
+			if ident.Name != name {
+				continue
+			}
+			ft, ok := field.Type.(*ast.FuncType)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s.%s is not a func", blk.qualifiedName(), name)
+			}
+			return ft, pkg, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%s has no field %s", blk.qualifiedName(), name)
+}
+
+// constructor returns the name of the component's constructor & whether the
+// component is used by pointer. A constructor is a function named New<T>
+// without parameters which returns T or *T. Without a constructor, the
+// component is used by pointer if its Render method has a pointer receiver.
+func (r *typeResolver) constructor(blk *ComponentStartBlock) (name string, ptr bool, err error) {
+	pkg, err := r.packageOf(blk)
+	if err != nil {
+		return "", false, err
+	} else if pkg.typeOf(blk.Name) == nil {
+		return "", false, fmt.Errorf("Cannot find type %s", blk.qualifiedName())
+	}
+
+	if fn := pkg.funcOf("New" + blk.Name); fn != nil && fn.Type.Params.NumFields() == 0 && fn.Type.Results.NumFields() == 1 {
+		typ := fn.Type.Results.List[0].Type
+		star, ptr := typ.(*ast.StarExpr)
+		if ptr {
+			typ = star.X
+		}
+		if ident, ok := typ.(*ast.Ident); ok && ident.Name == blk.Name {
+			return fn.Name.Name, ptr, nil
+		}
+	}
+
+	if fn := pkg.methodOf(blk.Name + ".Render"); fn != nil {
+		_, ptr := fn.Recv.List[0].Type.(*ast.StarExpr)
+		return "", ptr, nil
+	}
+	return "", false, nil
+}
+
+// packageOf returns the declarations of the component's package.
+func (r *typeResolver) packageOf(blk *ComponentStartBlock) (*packageDecls, error) {
+	if blk.Package == "" {
+		return r.r.load(filepath.Dir(r.t.Path))
+	}
+
+	if r.imports == nil {
+		r.imports = templateImports(r.t)
+	}
+	importPath, ok := r.imports[blk.Package]
+	if !ok {
+		return nil, fmt.Errorf("Cannot find import for package %s", blk.Package)
+	}
+	dir, err := r.r.importDir(importPath, filepath.Dir(r.t.Path))
+	if err != nil {
+		return nil, err
+	}
+	return r.r.load(dir)
+}
+
+// importDir returns the directory of the package imported by a file in srcDir.
+func (r *Resolver) importDir(importPath, srcDir string) (string, error) {
+	key := importPath + "\x00" + srcDir
+	if dir, ok := r.dirs[key]; ok {
+		return dir, nil
+	}
+
+	// Run the go command in srcDir so the import is found in srcDir's module.
+	srcDir, err := filepath.Abs(srcDir)
+	if err != nil {
+		return "", err
+	}
+	ctxt := build.Default
+	ctxt.Dir = srcDir
+	bp, err := ctxt.Import(importPath, srcDir, build.FindOnly)
+	if err != nil {
+		return "", err
+	}
+	r.dirs[key] = bp.Dir
+	return bp.Dir, nil
+}
+
+// load returns the declarations of the package in dir. Only the Go files are
+// read. Templates are read if a declaration is not found in the Go files.
+func (r *Resolver) load(dir string) (*packageDecls, error) {
+	if pkg := r.pkgs[dir]; pkg != nil {
+		return pkg, nil
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &packageDecls{
+		fset:    token.NewFileSet(),
+		types:   make(map[string]ast.Expr),
+		funcs:   make(map[string]*ast.FuncDecl),
+		methods: make(map[string]*ast.FuncDecl),
+		imports: make(map[string]map[string]string),
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() {
+			continue
+		}
+
+		switch {
+		case filepath.Ext(name) == ".ego":
+			pkg.templates = append(pkg.templates, filepath.Join(dir, name))
+		case filepath.Ext(name) == ".go" && !strings.HasSuffix(name, "_test.go"):
+			// Skip files which cannot be parsed, such as files being edited.
+			f, err := parser.ParseFile(pkg.fset, filepath.Join(dir, name), nil, 0)
+			if err != nil {
+				continue
+			}
+			pkg.add(f)
+		}
+	}
+
+	r.pkgs[dir] = pkg
+	return pkg, nil
+}
+
+// typeOf returns the type expression of the named type or nil if the type is
+// not declared by the package.
+func (pkg *packageDecls) typeOf(name string) ast.Expr {
+	if pkg.types[name] == nil {
+		pkg.readTemplates()
+	}
+	return pkg.types[name]
+}
+
+// funcOf returns the named function or nil if it is not declared by the package.
+func (pkg *packageDecls) funcOf(name string) *ast.FuncDecl {
+	if pkg.funcs[name] == nil {
+		pkg.readTemplates()
+	}
+	return pkg.funcs[name]
+}
+
+// methodOf returns the method with the key "Type.Method" or nil if it is not
+// declared by the package.
+func (pkg *packageDecls) methodOf(key string) *ast.FuncDecl {
+	if pkg.methods[key] == nil {
+		pkg.readTemplates()
+	}
+	return pkg.methods[key]
+}
+
+// readTemplates adds the declarations of the templates of the package which
+// are not declared by its Go files. Templates are generated without resolving
+// types. Templates which cannot be parsed or generated are skipped.
+func (pkg *packageDecls) readTemplates() {
+	for _, filename := range pkg.templates {
+		t, err := ParseFile(filename)
+		if err != nil {
+			continue
+		}
+		src, _, _, _ := t.generateWith(nil)
+
+		f, err := parser.ParseFile(pkg.fset, filename, src.Bytes(), 0)
+		if err != nil {
+			continue
+		}
+		pkg.add(f)
+	}
+	pkg.templates = nil
+}
+
+// add adds the top-level declarations of f which are not already declared.
+func (pkg *packageDecls) add(f *ast.File) {
+	pkg.name = f.Name.Name
+	imports := fileImports(f)
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if spec, ok := spec.(*ast.TypeSpec); ok && pkg.types[spec.Name.Name] == nil {
+					pkg.types[spec.Name.Name] = spec.Type
+					pkg.imports[spec.Name.Name] = imports
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil || len(decl.Recv.List) != 1 {
+				if pkg.funcs[decl.Name.Name] == nil {
+					pkg.funcs[decl.Name.Name] = decl
+				}
+				continue
+			}
+			key := receiverTypeName(decl.Recv.List[0].Type) + "." + decl.Name.Name
+			if pkg.methods[key] == nil {
+				pkg.methods[key] = decl
+			}
+		}
+	}
+}
+
+// receiverTypeName returns the name of a method receiver's base type.
+func receiverTypeName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// typeString returns the source of a type used by a field of the component
+// type as it is written in the template. Types from packages imported by the
+// component's file are written with the template's names for the packages.
+// Types declared by the component's package are prefixed with the
+// template's name for the package, unless it is the template's package.
+func (r *typeResolver) typeString(blk *ComponentStartBlock, pkg *packageDecls, expr ast.Expr) (string, error) {
+	if r.imports == nil {
+		r.imports = templateImports(r.t)
+	}
+
+	// Rewrite a copy of the expression so the package is not modified.
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, pkg.fset, expr); err != nil {
+		return "", err
+	}
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", buf.Bytes(), 0)
+	if err != nil {
+		return "", err
+	}
+
+	// Identifiers are renamed in place as the printer writes names verbatim.
+	imports := pkg.imports[blk.Name]
+	var visit func(node ast.Node) bool
+	visit = func(node ast.Node) bool {
+		if err != nil {
+			return false
+		}
+
+		switch node := node.(type) {
+		case *ast.Field:
+			// Skip parameter & field names.
+			ast.Inspect(node.Type, visit)
+			return false
+		case *ast.SelectorExpr:
+			x, ok := node.X.(*ast.Ident)
+			if !ok {
+				return false
+			}
+			importPath, ok := imports[x.Name]
+			if !ok {
+				return false
+			}
+			name, ok := r.importName(importPath)
+			if !ok {
+				err = fmt.Errorf("Cannot use %s.%s: package %q is not imported by the template", x.Name, node.Sel.Name, importPath)
+			}
+			x.Name = name
+			return false
+		case *ast.Ident:
+			if blk.Package != "" && pkg.typeOf(node.Name) != nil {
+				node.Name = blk.Package + "." + node.Name
+			}
+		}
+		return true
+	}
+	ast.Inspect(expr, visit)
+	if err != nil {
+		return "", err
+	}
+
+	buf.Reset()
+	if err := printer.Fprint(&buf, fset, expr); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// importName returns the name of the template's import of importPath.
+func (r *typeResolver) importName(importPath string) (string, bool) {
+	for name, other := range r.imports {
+		if other == importPath && name != "_" && name != "." {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// templateImports returns the import paths of the template by package name.
+// Imports are read from the code block holding the package clause.
+func templateImports(t *Template) map[string]string {
+	for _, blk := range t.Blocks {
+		blk, ok := blk.(*CodeBlock)
+		if !ok || !strings.HasPrefix(strings.TrimSpace(blk.Content), "package") {
+			continue
+		}
+
+		f, err := parser.ParseFile(token.NewFileSet(), "", blk.Content, parser.ImportsOnly)
+		if err != nil {
+			break
+		}
+		return fileImports(f)
+	}
+	return make(map[string]string)
+}
+
+// fileImports returns the import paths of a file by package name. Packages
+// imported without a name are assumed to be named after the last element of
+// their path.
+func fileImports(f *ast.File) map[string]string {
+	m := make(map[string]string)
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		m[name] = importPath
+	}
+	return m
+}
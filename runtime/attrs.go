@@ -0,0 +1,272 @@
+package runtime
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Attrs represents the passthrough attributes of a component. Attributes on
+// a component tag which do not start with an uppercase letter are set on the
+// component's Attrs field. A component writes them into one of its own tags
+// with a print block:
+//
+//	<div class="card"<%= r.Attrs %>>
+type Attrs map[string]string
+
+// NewAttrs returns attributes from alternating names & values. Values are
+// formatted as described by FormatAttrs. It is called by generated code to
+// set the Attrs field of a component.
+func NewAttrs(kv ...interface{}) Attrs {
+	a := make(Attrs, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		name := fmt.Sprint(kv[i])
+		if v, ok := attrValue(kv[i+1]); ok {
+			a[name] = v
+		} else {
+			delete(a, name)
+		}
+	}
+	return a
+}
+
+// FormatAttrs returns attributes from values of any type, such as the Attrs
+// field set by the TypedAttrs option. A nil or false value omits the
+// attribute & a true value adds it without a value. Slices are joined with
+// spaces, such as for a class list, & the keys of a map[string]bool with a
+// true value are joined with spaces. Other values are formatted with
+// fmt.Sprint.
+func FormatAttrs(attrs map[string]interface{}) Attrs {
+	a := make(Attrs, len(attrs))
+	for name, v := range attrs {
+		if v, ok := attrValue(v); ok {
+			a[name] = v
+		}
+	}
+	return a
+}
+
+// MergeAttrs returns the attributes of each map merged in order so that
+// attributes in later maps replace attributes in earlier maps. A nil or false
+// value removes the attribute. Each map must have string keys, such as Attrs
+// or map[string]interface{}. Values are formatted as described by
+// FormatAttrs. It is called by generated code for "ego:attrs".
+func MergeAttrs(maps ...interface{}) Attrs {
+	a := make(Attrs)
+	for _, m := range maps {
+		rangeAttrs(m, func(name string, v interface{}) {
+			if s, ok := attrValue(v); ok {
+				a[name] = s
+			} else {
+				delete(a, name)
+			}
+		})
+	}
+	return a
+}
+
+// MergeTypedAttrs returns the attributes of each map merged in order like
+// MergeAttrs but keeps the original values. It is called by generated code
+// for "ego:attrs" when the TypedAttrs option is set.
+func MergeTypedAttrs(maps ...interface{}) map[string]interface{} {
+	a := make(map[string]interface{})
+	for _, m := range maps {
+		rangeAttrs(m, func(name string, v interface{}) {
+			a[name] = v
+		})
+	}
+	return a
+}
+
+// rangeAttrs calls fn for each attribute in m. Panics if m is not a map
+// with string keys.
+func rangeAttrs(m interface{}, fn func(name string, v interface{})) {
+	switch m := m.(type) {
+	case nil:
+	case Attrs:
+		for name, v := range m {
+			fn(name, v)
+		}
+	case map[string]string:
+		for name, v := range m {
+			fn(name, v)
+		}
+	case map[string]interface{}:
+		for name, v := range m {
+			fn(name, v)
+		}
+	default:
+		rv := reflect.ValueOf(m)
+		if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			panic(fmt.Sprintf("ego: cannot use %T as attributes", m))
+		}
+		for iter := rv.MapRange(); iter.Next(); {
+			fn(iter.Key().String(), iter.Value().Interface())
+		}
+	}
+}
+
+// attrValue formats an attribute value. Returns false if the attribute is
+// omitted.
+func attrValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case bool:
+		return "", v
+	case string:
+		return v, true
+	case []string:
+		return strings.Join(v, " "), true
+	case []byte:
+		return string(v), true
+	case map[string]bool:
+		var names []string
+		for name, ok := range v {
+			if ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return strings.Join(names, " "), true
+	case fmt.Stringer, error:
+		if isNilPointer(v) {
+			return "", false
+		}
+		return fmt.Sprint(v), true
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return "", false
+		}
+	case reflect.Slice, reflect.Array:
+		a := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if s, ok := attrValue(rv.Index(i).Interface()); ok && s != "" {
+				a = append(a, s)
+			}
+		}
+		return strings.Join(a, " "), true
+	}
+	return fmt.Sprint(v), true
+}
+
+// String returns the attributes as HTML. Each attribute is preceded by a
+// space & attributes are sorted by name. Values are HTML escaped & URL
+// attributes with a disallowed scheme are replaced as in EscapeURL. Event
+// handlers & styles are written as-is, see JSAttr & CSSAttr. Attributes with
+// an empty value are written by name only. Names which are not valid HTML
+// attribute names are skipped.
+func (a Attrs) String() string {
+	var sb strings.Builder
+	a.WriteTo(&sb)
+	return sb.String()
+}
+
+// HTML returns the attributes as HTML so they are written unescaped by print
+// blocks in a tag. See String for the format.
+func (a Attrs) HTML() string {
+	return a.String()
+}
+
+// WriteTo writes the attributes to w as HTML. See String for the format.
+func (a Attrs) WriteTo(w io.Writer) (n int64, err error) {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		if isAttrName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := " " + name
+		if v := a[name]; v != "" {
+			if isURLAttr(name) {
+				v = EscapeURL(v)
+			}
+			s += `="` + html.EscapeString(v) + `"`
+		}
+
+		nn, err := io.WriteString(w, s)
+		if n += int64(nn); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// isURLAttr returns true if the named attribute holds a URL. The attribute
+// types match those used to escape templates.
+func isURLAttr(name string) bool {
+	// Strip namespace prefixes such as "xlink:href".
+	name = strings.ToLower(name)
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+
+	switch name {
+	case "action", "background", "cite", "codebase", "data", "formaction",
+		"href", "icon", "longdesc", "manifest", "poster", "profile", "src", "usemap":
+		return true
+	default:
+		return false
+	}
+}
+
+// JS is a trusted JavaScript snippet, such as an event handler written by
+// the template author. It is written as-is when passed to JSAttr.
+type JS string
+
+// CSS is a trusted list of CSS declarations, such as a style written by the
+// template author. It is written as-is when passed to CSSAttr.
+type CSS string
+
+// JSAttr returns the value of an event handler attribute, such as onclick,
+// set from an expression on a component. Values of type JS are trusted.
+// Other values are encoded as JavaScript values by EscapeJSAttrValue so they
+// cannot run as code. Nil & boolean values are returned unchanged so they
+// still omit or add the attribute.
+func JSAttr(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool:
+		return v
+	case JS:
+		return string(v)
+	}
+	return EscapeJSAttrValue(v)
+}
+
+// CSSAttr returns the value of a style attribute set from an expression on a
+// component. Values of type CSS are trusted. Other values are escaped by
+// EscapeCSS. Nil & boolean values are returned unchanged.
+func CSSAttr(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool:
+		return v
+	case CSS:
+		return string(v)
+	}
+	return EscapeCSS(v)
+}
+
+// isAttrName returns true if name can be written as an HTML attribute name.
+func isAttrName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		switch {
+		case ch <= ' ', ch == 0x7f:
+			return false
+		case strings.ContainsRune("\"'<>/=`", ch):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package runtime_test
+
+import (
+	"strings"
+	"testing"
+
+	ego "github.com/benbjohnson/ego/runtime"
+)
+
+// Ensure that attributes are built from names & values.
+func TestNewAttrs(t *testing.T) {
+	a := ego.NewAttrs("class", "btn", "disabled", true, "hidden", false, "title", nil, "tabindex", 1)
+	if got, want := a.String(), ` class="btn" disabled tabindex="1"`; got != want {
+		t.Fatalf("String()=%q, want %q", got, want)
+	}
+}
+
+// Ensure that attributes are written safely.
+func TestAttrs_String(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		attrs ego.Attrs
+		want  string
+	}{
+		{"Empty", nil, ``},
+		{"Sorted", ego.Attrs{"id": "x", "class": "a b"}, ` class="a b" id="x"`},
+		{"Escaped", ego.Attrs{"data-x": `"><script>alert(1)</script>`}, ` data-x="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;"`},
+		{"Boolean", ego.Attrs{"disabled": ""}, ` disabled`},
+		{"URL", ego.Attrs{"href": "javascript:alert(1)"}, ` href="#ZgotmplZ"`},
+		{"JS", ego.Attrs{"onclick": "save('a')"}, ` onclick="save(&#39;a&#39;)"`},
+		{"CSS", ego.Attrs{"style": "color: red"}, ` style="color: red"`},
+		{"InvalidName", ego.Attrs{`x" onclick="alert(1)`: "", "a": "b"}, ` a="b"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.attrs.String(); got != tt.want {
+				t.Fatalf("String()=%q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Ensure that author-written event handlers & styles are kept while other
+// values are escaped.
+func TestJSAttr_CSSAttr(t *testing.T) {
+	a := ego.NewAttrs(
+		"onclick", ego.JSAttr(ego.JS("save()")),
+		"onfocus", ego.JSAttr("alert(1)"),
+		"onblur", ego.JSAttr(nil),
+		"style", ego.CSSAttr(ego.CSS("color: red")),
+		"data-style", ego.CSSAttr("red; x: url(y)"),
+		"hidden", ego.CSSAttr(true),
+	)
+	if got, want := a.String(), ` data-style="red\3b  x\3a  url\28y\29" hidden onclick="save()" onfocus="&#34;alert(1)&#34;" style="color: red"`; got != want {
+		t.Fatalf("String()=%q, want %q", got, want)
+	}
+}
+
+// Ensure that attributes are written as trusted HTML.
+func TestAttrs_HTML(t *testing.T) {
+	var v interface{} = ego.Attrs{"class": "a"}
+	if h, ok := v.(ego.HTMLer); !ok {
+		t.Fatal("expected HTMLer")
+	} else if got, want := h.HTML(), ` class="a"`; got != want {
+		t.Fatalf("HTML()=%q, want %q", got, want)
+	}
+}
+
+// Ensure that attribute values of any type are formatted.
+func TestFormatAttrs(t *testing.T) {
+	var nilStringer *strings.Builder
+	a := ego.FormatAttrs(map[string]interface{}{
+		"class":    []string{"btn", "btn-lg"},
+		"data-ids": []int{1, 2},
+		"disabled": true,
+		"hidden":   false,
+		"title":    nil,
+		"id":       nilStringer,
+		"name":     "x",
+		"toggle":   map[string]bool{"on": true, "off": false, "active": true},
+	})
+	if got, want := a.String(), ` class="btn btn-lg" data-ids="1 2" disabled name="x" toggle="active on"`; got != want {
+		t.Fatalf("String()=%q, want %q", got, want)
+	}
+}
+
+// Ensure that later attributes replace attributes from earlier maps.
+func TestMergeAttrs(t *testing.T) {
+	spread := map[string]interface{}{"class": "a", "disabled": true, "id": "x"}
+	a := ego.MergeAttrs(nil, spread, ego.Attrs{"title": "t"}, map[string]interface{}{"class": "b", "disabled": false})
+	if got, want := a.String(), ` class="b" id="x" title="t"`; got != want {
+		t.Fatalf("String()=%q, want %q", got, want)
+	}
+
+	typed := ego.MergeTypedAttrs(spread, map[string]int{"tabindex": 1}, map[string]interface{}{"disabled": false})
+	if got, want := ego.FormatAttrs(typed).String(), ` class="a" id="x" tabindex="1"`; got != want {
+		t.Fatalf("String()=%q, want %q", got, want)
+	} else if typed["disabled"] != false {
+		t.Fatalf("unexpected value: %#v", typed["disabled"])
+	}
+}
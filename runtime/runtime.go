@@ -0,0 +1,484 @@
+// Package runtime provides the functions & types used by code generated by
+// ego. It is imported by generated code with the name "ego" & does not depend
+// on the template generator.
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The functions below are called by generated code to escape values printed
+// in contexts other than HTML element content.
+
+// unsafeURL is substituted for URLs with a disallowed scheme.
+const unsafeURL = "#ZgotmplZ"
+
+// EscapeURL filters and normalizes a value printed at the start of a URL attribute.
+// URLs with a scheme other than http, https, or mailto are replaced with "#ZgotmplZ".
+func EscapeURL(v interface{}) string {
+	s := fmt.Sprint(v)
+	if i := strings.IndexAny(s, ":/?#"); i >= 0 && s[i] == ':' {
+		switch strings.ToLower(s[:i]) {
+		case "http", "https", "mailto":
+		default:
+			return unsafeURL
+		}
+	}
+	return normalizeURL(s)
+}
+
+// NormalizeURL percent-encodes characters that are not allowed in a URL.
+// It is used for values printed within a URL before the query string.
+func NormalizeURL(v interface{}) string {
+	return normalizeURL(fmt.Sprint(v))
+}
+
+// EscapeURLQuery escapes a value printed in the query or fragment of a URL.
+func EscapeURLQuery(v interface{}) string {
+	return url.QueryEscape(fmt.Sprint(v))
+}
+
+func normalizeURL(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if ch := s[i]; isURLChar(ch) {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// isURLChar returns true if ch is an unreserved or reserved URL character, or '%'.
+func isURLChar(ch byte) bool {
+	switch {
+	case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~:/?#[]@!$&'()*+,;=%", ch) >= 0
+}
+
+// EscapeJSString escapes a value printed inside a JavaScript string literal.
+func EscapeJSString(v interface{}) string {
+	s := fmt.Sprint(v)
+
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\'', '"', '`', '<', '>', '&', '=', '+', '/', '\u2028', '\u2029':
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// EscapeJSTemplate escapes a value printed inside a JavaScript template
+// literal. In addition to the escaping of EscapeJSString, '$' & braces are
+// escaped so the value cannot start a template expression.
+func EscapeJSTemplate(v interface{}) string {
+	return jsTemplateReplacer.Replace(EscapeJSString(v))
+}
+
+var jsTemplateReplacer = strings.NewReplacer(`$`, `\u0024`, `{`, `\u007B`, `}`, `\u007D`)
+
+// EscapeJSValue encodes a value printed in JavaScript code as a JSON value.
+// Values which cannot be encoded are written as null. The value is padded
+// with spaces so it cannot join adjacent tokens.
+func EscapeJSValue(v interface{}) string {
+	return " " + jsValue(v) + " "
+}
+
+// EscapeJSAttrValue encodes a value printed in JavaScript code in an event
+// handler attribute as a JSON value. Unlike EscapeJSValue, it is not padded
+// with spaces since a space ends an unquoted attribute value.
+func EscapeJSAttrValue(v interface{}) string {
+	return jsValue(v)
+}
+
+// jsValue returns v encoded as a JSON value. Slashes are escaped so the value
+// cannot end a regular expression or comment if it is printed where one may
+// have started.
+func jsValue(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+
+	// JSON escapes <, >, & but single quotes & slashes are left as-is.
+	return jsValueReplacer.Replace(string(buf))
+}
+
+var jsValueReplacer = strings.NewReplacer(`'`, `\u0027`, `/`, `\/`)
+
+// EscapeJSRegexp escapes a value printed inside a JavaScript regular
+// expression literal so that it matches the value literally.
+func EscapeJSRegexp(v interface{}) string {
+	s := EscapeJSString(v)
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`.*?+^$|()[]{}`, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// EscapeCSS escapes a value printed in a style element or attribute.
+func EscapeCSS(v interface{}) string {
+	s := fmt.Sprint(v)
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+
+		if r >= utf8.RuneSelf || isCSSSafe(r) {
+			b.WriteRune(r)
+			continue
+		}
+
+		// Hex escapes are terminated by a space if they could be
+		// joined with a following hex digit or space.
+		fmt.Fprintf(&b, `\%x`, r)
+		if i < len(s) && (isHexDigit(s[i]) || s[i] == ' ') {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+func isCSSSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_.,#% ", r)
+}
+
+func isHexDigit(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+// EscapeUnquotedAttr escapes a value printed in an unquoted attribute value.
+// In addition to HTML escaping, characters that end the value are escaped.
+func EscapeUnquotedAttr(v interface{}) string {
+	s := html.EscapeString(fmt.Sprint(v))
+	return unquotedAttrReplacer.Replace(s)
+}
+
+var unquotedAttrReplacer = strings.NewReplacer(
+	" ", "&#32;",
+	"\t", "&#9;",
+	"\n", "&#10;",
+	"\r", "&#13;",
+	"\f", "&#12;",
+	"=", "&#61;",
+	"`", "&#96;",
+)
+
+// unsafeAttrName is substituted for attribute names with unsafe characters.
+const unsafeAttrName = "ZgotmplZ"
+
+// EscapeAttrName filters a value printed in an attribute name. Values with
+// characters other than ASCII letters, digits, '_' & '-' are replaced with
+// "ZgotmplZ" so they cannot end the name or add attributes.
+func EscapeAttrName(v interface{}) string {
+	s := fmt.Sprint(v)
+	for i := 0; i < len(s); i++ {
+		if ch := s[i]; !isAttrNameChar(ch) {
+			return unsafeAttrName
+		}
+	}
+	return s
+}
+
+func isAttrNameChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-'
+}
+
+// contextDone is the value panicked by CheckContext.
+type contextDone struct {
+	err error
+}
+
+// CheckContext panics if ctx is done. It is called by generated code when
+// the CheckContext option is set & the ReturnErrors option is not. The panic
+// is stopped by Recover.
+func CheckContext(ctx context.Context) {
+	if err := ctx.Err(); err != nil {
+		panic(contextDone{err: err})
+	}
+}
+
+// Recover stops a panic raised by CheckContext & sets *err to the context's
+// error. Other panics are not recovered. It must be deferred directly:
+//
+//	func render(ctx context.Context, w io.Writer, t *MyTemplate) (err error) {
+//		defer ego.Recover(&err)
+//		t.Render(ctx, w)
+//		return nil
+//	}
+func Recover(err *error) {
+	if r := recover(); r != nil {
+		done, ok := r.(contextDone)
+		if !ok {
+			panic(r)
+		}
+		*err = done.err
+	}
+}
+
+// HTML is a string of trusted HTML. Print blocks in element content or in a
+// tag write it unescaped. Use it only for HTML from a trusted source since it is not
+// checked or sanitized. In other contexts, it is escaped like any string.
+type HTML string
+
+// HTMLer is implemented by values which render themselves as trusted HTML.
+// Print blocks in element content or in a tag write the result of HTML
+// unescaped.
+type HTMLer interface {
+	HTML() string
+}
+
+// WriteHTML writes v to w as HTML. It is called by generated code for values
+// printed in element content. Values of type HTML & values implementing
+// HTMLer are written unescaped. Other values are escaped by WriteEscaped.
+func WriteHTML(w io.Writer, v interface{}) (int, error) {
+	switch v := v.(type) {
+	case HTML:
+		return io.WriteString(w, string(v))
+	case HTMLer:
+		if !isNilPointer(v) {
+			return io.WriteString(w, v.HTML())
+		}
+	}
+	return WriteEscaped(w, v)
+}
+
+// WriteTagHTML writes v to w in a tag, outside of attribute values. It is
+// called by generated code for values printed in a tag, such as ego.Attrs.
+// Values of type HTML & values implementing HTMLer are written unescaped.
+// Other values are written as an attribute name filtered by EscapeAttrName.
+func WriteTagHTML(w io.Writer, v interface{}) (int, error) {
+	switch v := v.(type) {
+	case HTML:
+		return io.WriteString(w, string(v))
+	case HTMLer:
+		if !isNilPointer(v) {
+			return io.WriteString(w, v.HTML())
+		}
+	}
+	return io.WriteString(w, EscapeAttrName(v))
+}
+
+// WriteEscaped writes v to w as HTML escaped text. It is called by generated
+// code for values printed in quoted attribute values & other contexts where
+// trusted HTML is not allowed.
+//
+// The output matches html.EscapeString(fmt.Sprint(v)) except that byte slices
+// are written as text. Strings, byte slices, numbers, and booleans are
+// escaped directly into w. However, converting a value to an interface may
+// allocate so generated code calls WriteEscapedString, WriteInt, etc. instead
+// when the type of the printed value is known.
+func WriteEscaped(w io.Writer, v interface{}) (int, error) {
+	switch v := v.(type) {
+	case string:
+		return WriteEscapedString(w, v)
+	case HTML:
+		return WriteEscapedString(w, string(v))
+	case []byte:
+		return WriteEscapedBytes(w, v)
+	case int:
+		return WriteInt(w, int64(v))
+	case int8:
+		return WriteInt(w, int64(v))
+	case int16:
+		return WriteInt(w, int64(v))
+	case int32:
+		return WriteInt(w, int64(v))
+	case int64:
+		return WriteInt(w, v)
+	case uint:
+		return WriteUint(w, uint64(v))
+	case uint8:
+		return WriteUint(w, uint64(v))
+	case uint16:
+		return WriteUint(w, uint64(v))
+	case uint32:
+		return WriteUint(w, uint64(v))
+	case uint64:
+		return WriteUint(w, v)
+	case uintptr:
+		return WriteUint(w, uint64(v))
+	case float32:
+		return WriteFloat(w, float64(v), 32)
+	case float64:
+		return WriteFloat(w, v, 64)
+	case bool:
+		return WriteBool(w, v)
+	case fmt.Formatter:
+		// Formatters may print differently than their String method.
+	case error:
+		if !isNilPointer(v) {
+			return WriteEscapedString(w, v.Error())
+		}
+	case fmt.Stringer:
+		if !isNilPointer(v) {
+			return WriteEscapedString(w, v.String())
+		}
+	}
+	return WriteEscapedString(w, fmt.Sprint(v))
+}
+
+// isNilPointer returns true if v is a nil pointer. These are printed by fmt
+// since it recovers from a panicking method on a nil receiver.
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// WriteEscapedString writes s to w with the same escaping as
+// html.EscapeString. Unescaped runs of s are written as-is so no memory is
+// allocated. It is called by generated code for printed values that are known
+// to be strings.
+func WriteEscapedString(w io.Writer, s string) (n int, err error) {
+	last := 0
+	for i := 0; i < len(s); i++ {
+		entity := htmlEntity(s[i])
+		if entity == "" {
+			continue
+		}
+
+		if last < i {
+			nn, err := io.WriteString(w, s[last:i])
+			if n += nn; err != nil {
+				return n, err
+			}
+		}
+		nn, err := io.WriteString(w, entity)
+		if n += nn; err != nil {
+			return n, err
+		}
+		last = i + 1
+	}
+
+	if last < len(s) {
+		nn, err := io.WriteString(w, s[last:])
+		n += nn
+		return n, err
+	}
+	return n, nil
+}
+
+// WriteEscapedBytes writes b to w with the same escaping as html.EscapeString.
+// It is called by generated code for printed values that are known to be byte
+// slices.
+func WriteEscapedBytes(w io.Writer, b []byte) (n int, err error) {
+	last := 0
+	for i := 0; i < len(b); i++ {
+		entity := htmlEntity(b[i])
+		if entity == "" {
+			continue
+		}
+
+		if last < i {
+			nn, err := w.Write(b[last:i])
+			if n += nn; err != nil {
+				return n, err
+			}
+		}
+		nn, err := io.WriteString(w, entity)
+		if n += nn; err != nil {
+			return n, err
+		}
+		last = i + 1
+	}
+
+	if last < len(b) {
+		nn, err := w.Write(b[last:])
+		n += nn
+		return n, err
+	}
+	return n, nil
+}
+
+// htmlEntity returns the entity that replaces ch when HTML escaping, if any.
+func htmlEntity(ch byte) string {
+	switch ch {
+	case '<':
+		return "&lt;"
+	case '>':
+		return "&gt;"
+	case '&':
+		return "&amp;"
+	case '\'':
+		return "&#39;"
+	case '"':
+		return "&#34;"
+	}
+	return ""
+}
+
+// numberBufferPool holds buffers used to format numbers without allocating.
+// Numbers never need to be HTML escaped.
+var numberBufferPool = sync.Pool{
+	New: func() interface{} { return new([64]byte) },
+}
+
+// WriteInt writes v to w in base 10. It is called by generated code for
+// printed values that are known to be signed integers.
+func WriteInt(w io.Writer, v int64) (int, error) {
+	buf := numberBufferPool.Get().(*[64]byte)
+	defer numberBufferPool.Put(buf)
+	return w.Write(strconv.AppendInt(buf[:0], v, 10))
+}
+
+// WriteUint writes v to w in base 10. It is called by generated code for
+// printed values that are known to be unsigned integers.
+func WriteUint(w io.Writer, v uint64) (int, error) {
+	buf := numberBufferPool.Get().(*[64]byte)
+	defer numberBufferPool.Put(buf)
+	return w.Write(strconv.AppendUint(buf[:0], v, 10))
+}
+
+// WriteFloat writes v to w as fmt.Sprint would format a float of bitSize
+// bits. It is called by generated code for printed values that are known to
+// be floats.
+func WriteFloat(w io.Writer, v float64, bitSize int) (int, error) {
+	buf := numberBufferPool.Get().(*[64]byte)
+	defer numberBufferPool.Put(buf)
+	return w.Write(strconv.AppendFloat(buf[:0], v, 'g', -1, bitSize))
+}
+
+// WriteBool writes v to w as "true" or "false". It is called by generated
+// code for printed values that are known to be booleans.
+func WriteBool(w io.Writer, v bool) (int, error) {
+	return io.WriteString(w, strconv.FormatBool(v))
+}
@@ -0,0 +1,155 @@
+package runtime_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"testing"
+	"time"
+
+	ego "github.com/benbjohnson/ego/runtime"
+)
+
+// Ensure that Recover returns the context error raised by CheckContext.
+func TestRecover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	render := func() (err error) {
+		defer ego.Recover(&err)
+		ego.CheckContext(ctx)
+		t.Fatal("expected panic")
+		return nil
+	}
+	if err := render(); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that Recover does not stop other panics.
+func TestRecover_OtherPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Fatalf("unexpected panic: %v", r)
+		}
+	}()
+
+	func() {
+		var err error
+		defer ego.Recover(&err)
+		panic("boom")
+	}()
+}
+
+// Ensure that trusted HTML is written unescaped & other values are escaped.
+func TestWriteHTML(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{ego.HTML("<b>Hi</b>"), "<b>Hi</b>"},
+		{htmler("<i>x</i>"), "<i>x</i>"},
+		{(*htmlerPtr)(nil), "&lt;nil&gt;"},
+		{"<b>Hi</b>", "&lt;b&gt;Hi&lt;/b&gt;"},
+		{42, "42"},
+	} {
+		var buf bytes.Buffer
+		if _, err := ego.WriteHTML(&buf, tt.v); err != nil {
+			t.Fatal(err)
+		} else if buf.String() != tt.want {
+			t.Fatalf("WriteHTML(%#v)=%q, want %q", tt.v, buf.String(), tt.want)
+		}
+	}
+}
+
+// Ensure that trusted HTML is written unescaped in a tag & other values are
+// written as attribute names.
+func TestWriteTagHTML(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{ego.HTML(` a="b"`), ` a="b"`},
+		{htmler(` c="d"`), ` c="d"`},
+		{"disabled", "disabled"},
+		{"data-x_1", "data-x_1"},
+		{"onclick=alert(1)", "ZgotmplZ"},
+		{"a b", "ZgotmplZ"},
+		{42, "42"},
+	} {
+		var buf bytes.Buffer
+		if _, err := ego.WriteTagHTML(&buf, tt.v); err != nil {
+			t.Fatal(err)
+		} else if buf.String() != tt.want {
+			t.Fatalf("WriteTagHTML(%#v)=%q, want %q", tt.v, buf.String(), tt.want)
+		}
+	}
+}
+
+type htmler string
+
+func (h htmler) HTML() string { return string(h) }
+
+type htmlerPtr struct{}
+
+func (h *htmlerPtr) HTML() string { return "<p></p>" }
+
+// Ensure that values are written with the same escaping as html.EscapeString.
+func TestWriteEscaped(t *testing.T) {
+	var nilTime *time.Time
+	for _, v := range []interface{}{
+		"", "plain", `<a href="x">Tom & Jerry's</a>`,
+		-42, int8(1), int16(2), int32(3), int64(-4), uint(5), uint8(6), uint16(7), uint32(8), uint64(9), uintptr(10),
+		float32(3.1415927), 123456789.0, 1e21, 0.00001, true, false, ego.HTML("<b>"),
+		errors.New("<err>"), time.Duration(90) * time.Second, nilTime, nil, struct{ A string }{"<"},
+	} {
+		var buf bytes.Buffer
+		n, err := ego.WriteEscaped(&buf, v)
+		if err != nil {
+			t.Fatal(err)
+		} else if want := html.EscapeString(fmt.Sprint(v)); buf.String() != want {
+			t.Fatalf("WriteEscaped(%#v)=%q, want %q", v, buf.String(), want)
+		} else if n != buf.Len() {
+			t.Fatalf("WriteEscaped(%#v) returned n=%d, want %d", v, n, buf.Len())
+		}
+	}
+
+	// Byte slices are written as text.
+	var buf bytes.Buffer
+	if _, err := ego.WriteEscaped(&buf, []byte("a<b")); err != nil {
+		t.Fatal(err)
+	} else if got, want := buf.String(), "a&lt;b"; got != want {
+		t.Fatalf("WriteEscaped([]byte)=%q, want %q", got, want)
+	}
+}
+
+// Ensure that strings & numbers are written without allocating.
+func TestWriteEscaped_Allocs(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Grow(1024)
+
+	// Values are passed as generated code passes them for each type.
+	s, b, i, u, f, ok := "Tom & Jerry", []byte("<b>"), 123456789, uint16(42), float32(1.5), true
+	for _, tt := range []struct {
+		name string
+		fn   func()
+	}{
+		{"String", func() { _, _ = ego.WriteEscapedString(&buf, s) }},
+		{"Bytes", func() { _, _ = ego.WriteEscapedBytes(&buf, b) }},
+		{"Int", func() { _, _ = ego.WriteInt(&buf, int64(i)) }},
+		{"Uint", func() { _, _ = ego.WriteUint(&buf, uint64(u)) }},
+		{"Float", func() { _, _ = ego.WriteFloat(&buf, float64(f), 32) }},
+		{"Bool", func() { _, _ = ego.WriteBool(&buf, ok) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := testing.AllocsPerRun(100, func() {
+				buf.Reset()
+				tt.fn()
+			}); n != 0 {
+				t.Fatalf("allocated %v times", n)
+			}
+		})
+	}
+}
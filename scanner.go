@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -27,8 +30,10 @@ func NewScanner(r io.Reader, path string) *Scanner {
 	return &Scanner{
 		r: r,
 		pos: Pos{
-			Path:   path,
-			LineNo: 1,
+			Path:      path,
+			LineNo:    1,
+			ColNo:     1,
+			RuneColNo: 1,
 		},
 	}
 }
@@ -52,11 +57,15 @@ func (s *Scanner) Scan() (Block, error) {
 			return s.scanAttrEndBlock()
 		}
 
-		// Special handling for ego blocks.
-		if s.peekN(4) == "<%==" {
+		// Special handling for ego blocks. An escaped "<%%" is text.
+		if s.peekN(3) == "<%%" {
+			return s.scanTextBlock()
+		} else if s.peekN(5) == "<%-==" || s.peekN(4) == "<%==" {
 			return s.scanRawPrintBlock()
-		} else if s.peekN(3) == "<%=" {
+		} else if s.peekN(4) == "<%-=" || s.peekN(3) == "<%=" {
 			return s.scanPrintBlock()
+		} else if s.peekN(3) == "<%#" {
+			return s.scanCommentBlock()
 		} else if s.peekN(2) == "<%" {
 			return s.scanCodeBlock()
 		}
@@ -68,14 +77,15 @@ func (s *Scanner) Scan() (Block, error) {
 }
 
 func (s *Scanner) scanTextBlock() (*TextBlock, error) {
-	buf := bytes.NewBufferString(s.readN(1))
 	b := &TextBlock{Pos: s.pos}
+	var buf bytes.Buffer
+	s.scanText(&buf)
 
 	for {
-		if ch := s.peek(); ch == eof || ch == '<' {
+		if ch := s.peek(); ch == eof || (ch == '<' && s.peekN(3) != "<%%") {
 			break
 		}
-		buf.WriteRune(s.read())
+		s.scanText(&buf)
 	}
 
 	b.Content = string(buf.Bytes())
@@ -83,43 +93,96 @@ func (s *Scanner) scanTextBlock() (*TextBlock, error) {
 	return b, nil
 }
 
+// scanText reads the next rune of text into buf.
+// The escaped delimiters "<%%" and "%%>" are written as "<%" and "%>".
+func (s *Scanner) scanText(buf *bytes.Buffer) {
+	switch str := s.peekN(3); str {
+	case "<%%":
+		s.readN(3)
+		buf.WriteString("<%")
+	case "%%>":
+		s.readN(3)
+		buf.WriteString("%>")
+	default:
+		_, raw := s.readRaw()
+		buf.Write(raw)
+	}
+}
+
 func (s *Scanner) scanCodeBlock() (*CodeBlock, error) {
 	b := &CodeBlock{Pos: s.pos}
 	assert(s.readN(2) == "<%")
+	b.TrimLeft = s.scanTrimMarker()
 
-	content, err := s.scanContent()
+	content, trimRight, err := s.scanContent()
 	if err != nil {
 		return nil, err
 	}
-	b.Content = content
+	b.Content, b.TrimRight = content, trimRight
 
 	return b, nil
 }
 
 func (s *Scanner) scanPrintBlock() (*PrintBlock, error) {
 	b := &PrintBlock{Pos: s.pos}
-	assert(s.readN(3) == "<%=")
+	assert(s.readN(2) == "<%")
+	b.TrimLeft = s.scanTrimMarker()
+	assert(s.read() == '=')
 
-	content, err := s.scanContent()
+	content, trimRight, err := s.scanContent()
 	if err != nil {
 		return nil, err
 	}
-	b.Content = content
+	b.Content, b.TrimRight = content, trimRight
 	return b, nil
 }
 
 func (s *Scanner) scanRawPrintBlock() (*RawPrintBlock, error) {
 	b := &RawPrintBlock{Pos: s.pos}
-	assert(s.readN(4) == "<%==")
+	assert(s.readN(2) == "<%")
+	b.TrimLeft = s.scanTrimMarker()
+	assert(s.readN(2) == "==")
 
-	content, err := s.scanContent()
+	content, trimRight, err := s.scanContent()
 	if err != nil {
 		return nil, err
 	}
-	b.Content = content
+	b.Content, b.TrimRight = content, trimRight
 	return b, nil
 }
 
+func (s *Scanner) scanCommentBlock() (*CommentBlock, error) {
+	b := &CommentBlock{Pos: s.pos}
+	assert(s.readN(3) == "<%#")
+
+	// Comments are not Go code so read everything up to the close tag.
+	var buf bytes.Buffer
+	for {
+		if s.peekN(2) == "%>" {
+			s.readN(2)
+			break
+		}
+
+		ch, raw := s.readRaw()
+		if ch == eof {
+			return nil, &SyntaxError{Message: "Expected close tag, found EOF", Pos: s.pos}
+		}
+		buf.Write(raw)
+	}
+	b.Content = buf.String()
+
+	return b, nil
+}
+
+// scanTrimMarker reads a leading trim marker, if one exists.
+func (s *Scanner) scanTrimMarker() bool {
+	if s.peek() != '-' {
+		return false
+	}
+	s.read()
+	return true
+}
+
 func (s *Scanner) peekComponentStartBlock() bool {
 	pos, i := s.pos, s.i
 	defer func() { s.pos, s.i = pos, i }()
@@ -136,6 +199,13 @@ func (s *Scanner) peekComponentStartBlock() bool {
 	return true
 }
 
+// Attribute names with a special meaning on a component.
+const (
+	spreadAttrName = "ego:attrs" // passes through a map of attributes
+	condAttrName   = "ego:if"    // sets the preceding field or attribute conditionally
+	letAttrPrefix  = "let:"      // binds a closure parameter to a variable
+)
+
 func (s *Scanner) scanComponentStartBlock() (_ *ComponentStartBlock, err error) {
 	b := &ComponentStartBlock{Pos: s.pos}
 	assert(s.read() == '<')
@@ -155,7 +225,10 @@ func (s *Scanner) scanComponentStartBlock() (_ *ComponentStartBlock, err error)
 		return nil, err
 	}
 
-	// Scan attributes & fields.
+	// Scan attributes & fields. The condition of the last field or
+	// attribute is set by a following "ego:if".
+	var cond *string
+	var condPos *Pos
 	for {
 		s.skipWhitespace()
 		if ch := s.peek(); ch == '>' {
@@ -173,6 +246,7 @@ func (s *Scanner) scanComponentStartBlock() (_ *ComponentStartBlock, err error)
 				return nil, err
 			}
 			b.Fields = append(b.Fields, field)
+			cond, condPos = &field.Cond, &field.CondPos
 			continue
 		}
 
@@ -180,7 +254,39 @@ func (s *Scanner) scanComponentStartBlock() (_ *ComponentStartBlock, err error)
 		if err != nil {
 			return nil, err
 		}
-		b.Attrs = append(b.Attrs, attr)
+
+		switch {
+		case strings.HasPrefix(attr.Name, letAttrPrefix):
+			// Parameters of the Yield closure are bound by "let:name=var".
+			let, err := newLet(attr)
+			if err != nil {
+				return nil, err
+			}
+			b.Lets = append(b.Lets, let)
+			cond, condPos = nil, nil
+
+		case attr.Name == spreadAttrName:
+			// Attributes may be passed through from a map, such as "ego:attrs=r.Attrs".
+			if attr.ValuePos == (Pos{}) {
+				return nil, NewSyntaxError(attr.NamePos, "Expected '=' after %s", spreadAttrName)
+			}
+			b.Spreads = append(b.Spreads, &Spread{NamePos: attr.NamePos, Value: attr.Value, ValuePos: attr.ValuePos})
+			cond, condPos = nil, nil
+
+		case attr.Name == condAttrName:
+			// A condition applies to the preceding field or attribute.
+			if attr.ValuePos == (Pos{}) {
+				return nil, NewSyntaxError(attr.NamePos, "Expected '=' after %s", condAttrName)
+			} else if cond == nil {
+				return nil, NewSyntaxError(attr.NamePos, "%s must follow a field or attribute", condAttrName)
+			}
+			*cond, *condPos = attr.Value, attr.ValuePos
+			cond, condPos = nil, nil
+
+		default:
+			b.Attrs = append(b.Attrs, attr)
+			cond, condPos = &attr.Cond, &attr.CondPos
+		}
 	}
 
 	return b, nil
@@ -225,8 +331,8 @@ func (s *Scanner) scanComponentEndBlock() (_ *ComponentEndBlock, err error) {
 	s.skipWhitespace()
 
 	// Scan close.
-	if ch := s.read(); ch != '>' {
-		return nil, NewSyntaxError(s.pos, "Expected '>', found %s", runeString(ch))
+	if pos, ch := s.pos, s.read(); ch != '>' {
+		return nil, NewSyntaxError(pos, "Expected '>', found %s", runeString(ch))
 	}
 
 	return b, nil
@@ -267,16 +373,53 @@ func (s *Scanner) scanAttrStartBlock() (_ *AttrStartBlock, err error) {
 	if b.Name, err = s.scanIdent(); err != nil {
 		return nil, err
 	}
-	s.skipWhitespace()
 
-	// Scan close.
-	if ch := s.read(); ch != '>' {
-		return nil, NewSyntaxError(s.pos, "Expected '>', found %s", runeString(ch))
+	// Scan closure parameters, such as "let:item=row".
+	for {
+		s.skipWhitespace()
+		if s.peek() == '>' {
+			s.read()
+			break
+		}
+
+		attr, err := s.scanAttr()
+		if err != nil {
+			return nil, err
+		} else if !strings.HasPrefix(attr.Name, letAttrPrefix) {
+			return nil, NewSyntaxError(attr.NamePos, "Expected '>' or %s attribute, found %s", letAttrPrefix, attr.Name)
+		}
+
+		let, err := newLet(attr)
+		if err != nil {
+			return nil, err
+		}
+		b.Lets = append(b.Lets, let)
 	}
 
 	return b, nil
 }
 
+// newLet returns a closure parameter from a "let:" attribute. The value is
+// the name of the variable, either quoted or bare.
+func newLet(attr *Attr) (*Let, error) {
+	name := strings.TrimPrefix(attr.Name, letAttrPrefix)
+	if !token.IsIdentifier(name) {
+		return nil, NewSyntaxError(attr.NamePos, "Invalid parameter name: %s", name)
+	} else if attr.ValuePos == (Pos{}) {
+		return nil, NewSyntaxError(attr.NamePos, "Expected '=' after %s", attr.Name)
+	}
+
+	value := attr.Value
+	if s, err := strconv.Unquote(value); err == nil {
+		value = s
+	}
+	if !token.IsIdentifier(value) {
+		return nil, NewSyntaxError(attr.ValuePos, "Invalid variable name: %s", attr.Value)
+	}
+
+	return &Let{Name: name, NamePos: attr.NamePos, Value: value, ValuePos: attr.ValuePos}, nil
+}
+
 func (s *Scanner) peekAttrEndBlock() bool {
 	pos, i := s.pos, s.i
 	defer func() { s.pos, s.i = pos, i }()
@@ -317,35 +460,75 @@ func (s *Scanner) scanAttrEndBlock() (_ *AttrEndBlock, err error) {
 	s.skipWhitespace()
 
 	// Scan close.
-	if ch := s.read(); ch != '>' {
-		return nil, NewSyntaxError(s.pos, "Expected '>', found %s", runeString(ch))
+	if pos, ch := s.pos, s.read(); ch != '>' {
+		return nil, NewSyntaxError(pos, "Expected '>', found %s", runeString(ch))
 	}
 
 	return b, nil
 }
 
-// scans the reader until %> is reached.
-func (s *Scanner) scanContent() (string, error) {
+// scans the reader until %> is reached. Returns true if the content ends
+// with a "-" trim marker. A "--" before the close tag is not a trim marker.
+//
+// The content is scanned as Go source so a "%>" inside of a string, rune,
+// or comment does not close the block. An escaped "%%>" is written as "%>".
+func (s *Scanner) scanContent() (content string, trimRight bool, err error) {
 	var buf bytes.Buffer
+	var quote rune     // open string or rune quote character
+	var comment string // open comment, either "//" or "/*"
 	for {
-		ch := s.read()
+		ch, raw := s.readRaw()
 		if ch == eof {
-			return "", &SyntaxError{Message: "Expected close tag, found EOF", Pos: s.pos}
-		} else if ch == '%' {
-			ch := s.read()
-			if ch == eof {
-				return "", &SyntaxError{Message: "Expected close tag, found EOF", Pos: s.pos}
-			} else if ch == '>' {
-				break
-			} else {
-				buf.WriteRune('%')
+			return "", false, &SyntaxError{Message: "Expected close tag, found EOF", Pos: s.pos}
+		}
+
+		switch {
+		case comment == "//" && ch == '\n':
+			comment = ""
+
+		case comment == "/*":
+			if ch == '*' && s.peek() == '/' {
+				buf.WriteRune(ch)
+				_, raw = s.readRaw()
+				comment = ""
+			}
+
+		case quote != 0:
+			if ch == '\\' && quote != '`' && s.peek() != eof {
 				buf.WriteRune(ch)
+				_, raw = s.readRaw()
+			} else if ch == quote {
+				quote = 0
+			}
+
+		// Line comments also end at the close tag. Otherwise the text after
+		// a block such as "<% x := 1 // note %>" would be commented out.
+		case ch == '%' && s.peekN(2) == "%>":
+			s.readN(2)
+			buf.WriteString("%>")
+			continue
+
+		case ch == '%' && s.peek() == '>':
+			s.read()
+			content = buf.String()
+			if strings.HasSuffix(content, "-") && !strings.HasSuffix(content, "--") {
+				return strings.TrimSuffix(content, "-"), true, nil
 			}
-		} else {
+			return content, false, nil
+
+		case comment == "//":
+
+		case ch == '"' || ch == '`' || ch == '\'':
+			quote = ch
+
+		case ch == '/' && (s.peek() == '/' || s.peek() == '*'):
 			buf.WriteRune(ch)
+			ch, raw = s.readRaw()
+			comment = "/" + string(ch)
 		}
+
+		buf.Write(raw)
 	}
-	return string(buf.Bytes()), nil
 }
 
 func (s *Scanner) scanField() (*Field, error) {
@@ -367,8 +550,8 @@ func (s *Scanner) scanField() (*Field, error) {
 	}
 
 	// Expect an equals sign next.
-	if ch := s.read(); ch != '=' {
-		return nil, NewSyntaxError(s.pos, "Expected '=', found %s", runeString(ch))
+	if pos, ch := s.pos, s.read(); ch != '=' {
+		return nil, NewSyntaxError(pos, "Expected '=', found %s", runeString(ch))
 	}
 	s.skipWhitespace()
 
@@ -406,8 +589,8 @@ func (s *Scanner) scanAttr() (*Attr, error) {
 	}
 
 	// Expect an equals sign next.
-	if ch := s.read(); ch != '=' {
-		return nil, NewSyntaxError(s.pos, "Expected '=', found %s", runeString(ch))
+	if pos, ch := s.pos, s.read(); ch != '=' {
+		return nil, NewSyntaxError(pos, "Expected '=', found %s", runeString(ch))
 	}
 	s.skipWhitespace()
 
@@ -435,9 +618,9 @@ func (s *Scanner) scanIdent() (string, error) {
 	var buf bytes.Buffer
 
 	// First rune must be a letter.
-	ch := s.read()
+	pos, ch := s.pos, s.read()
 	if !isIdentStart(ch) {
-		return "", NewSyntaxError(s.pos, "Expected identifier, found %s", runeString(ch))
+		return "", NewSyntaxError(pos, "Expected identifier, found %s", runeString(ch))
 	}
 	buf.WriteRune(ch)
 
@@ -453,9 +636,9 @@ func (s *Scanner) scanAttrName() (string, error) {
 	var buf bytes.Buffer
 
 	// First rune must be a letter.
-	ch := s.read()
+	pos, ch := s.pos, s.read()
 	if !isIdentStart(ch) {
-		return "", NewSyntaxError(s.pos, "Expected identifier, found %s", runeString(ch))
+		return "", NewSyntaxError(pos, "Expected identifier, found %s", runeString(ch))
 	}
 	buf.WriteRune(ch)
 
@@ -501,6 +684,19 @@ func (s *Scanner) scanExpr() (string, error) {
 	return buf.String(), nil
 }
 
+// skipToNextBlock moves the scanner forward to the next code, print, comment,
+// component, or attribute block. This is used to resume scanning after an error.
+func (s *Scanner) skipToNextBlock() {
+	for ch := s.peek(); ch != eof; ch = s.peek() {
+		if ch == '<' && (s.peekN(2) == "<%" ||
+			s.peekComponentStartBlock() || s.peekComponentEndBlock() ||
+			s.peekAttrStartBlock() || s.peekAttrEndBlock()) {
+			return
+		}
+		s.read()
+	}
+}
+
 func (s *Scanner) scanWhitespace() string {
 	var buf bytes.Buffer
 	for ch := s.peek(); isWhitespace(ch); ch = s.peek() {
@@ -526,13 +722,19 @@ func (s *Scanner) read() rune {
 
 	ch, n := utf8.DecodeRune(s.b[s.i:])
 	s.i += n
-
-	if ch == '\n' {
-		s.pos.LineNo++
-	}
+	s.pos = s.pos.advance(ch, n)
 	return ch
 }
 
+// readRaw reads the next rune like read & also returns its bytes in the
+// source. Content is built from these bytes so invalid UTF-8 is kept as-is
+// & positions within the content match the source.
+func (s *Scanner) readRaw() (rune, []byte) {
+	i := s.i
+	ch := s.read()
+	return ch, s.b[i:s.i]
+}
+
 // readN reads the next n characters and moves the position forward.
 func (s *Scanner) readN(n int) string {
 	var buf bytes.Buffer
@@ -613,8 +815,32 @@ func NewSyntaxError(pos Pos, format string, args ...interface{}) *SyntaxError {
 	}
 }
 
+// Error returns the error message prefixed with its "path:line:col" position.
 func (e *SyntaxError) Error() string {
-	return fmt.Sprintf("%s at %s:%d", e.Message, e.Pos.Path, e.Pos.LineNo)
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// ErrorList represents a list of syntax errors.
+type ErrorList []*SyntaxError
+
+// Error returns the first error and the count of any additional errors.
+func (a ErrorList) Error() string {
+	switch len(a) {
+	case 0:
+		return "no errors"
+	case 1:
+		return a[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", a[0], len(a)-1)
+	}
+}
+
+// Err returns the list as an error. Returns nil if the list is empty.
+func (a ErrorList) Err() error {
+	if len(a) == 0 {
+		return nil
+	}
+	return a
 }
 
 func isIdentStart(ch rune) bool {
@@ -20,7 +20,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected block type: %T", blk)
 			} else if blk.Content != "hello world" {
 				t.Fatalf("unexpected content: %s", blk.Content)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -36,6 +36,17 @@ func TestScanner(t *testing.T) {
 			}
 		})
 
+		t.Run("EscapedDelimiters", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%% x %%> 100%"), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "<% x %> 100%" {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			}
+		})
+
 		t.Run("SingleLT", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString("<html>"), "tmpl.ego")
 			if blk, err := s.Scan(); err != nil {
@@ -46,6 +57,25 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected content: %s", blk.Content)
 			}
 		})
+
+		t.Run("InvalidUTF8", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("\xffé<%= x %>"), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != "\xffé" {
+				t.Fatalf("unexpected content: %q", blk.Content)
+			}
+
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.PrintBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 4, RuneColNo: 3, Offset: 3}) {
+				t.Fatalf("unexpected pos: %#v", blk.Pos)
+			}
+		})
 	})
 
 	t.Run("CodeBlock", func(t *testing.T) {
@@ -57,49 +87,177 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected block type: %T", blk)
 			} else if blk.Content != " x := 1 " {
 				t.Fatalf("unexpected content: %s", blk.Content)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
 
+		t.Run("TrimMarkers", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%- x := 1 -%>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.CodeBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != " x := 1 " {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			} else if !blk.TrimLeft || !blk.TrimRight {
+				t.Fatalf("unexpected trim markers: %v, %v", blk.TrimLeft, blk.TrimRight)
+			}
+		})
+
+		t.Run("GoSource", func(t *testing.T) {
+			for _, tt := range []struct {
+				name    string
+				text    string
+				content string
+			}{
+				{"String", `<% x := "%>" %>`, ` x := "%>" `},
+				{"StringEscapedQuote", `<% x := "\"%>" %>`, ` x := "\"%>" `},
+				{"RawString", "<% x := `\\%>` %>", " x := `\\%>` "},
+				{"Rune", `<% x := '%' %>`, ` x := '%' `},
+				{"LineComment", "<% x := 1 // note\ny := \"%>\" %>", " x := 1 // note\ny := \"%>\" "},
+				{"LineCommentCloseTag", "<% x := 1 // \"note %>\n%>", " x := 1 // \"note "},
+				{"LineCommentEscapedDelimiter", "<% x := 1 // %%>\n%>", " x := 1 // %>\n"},
+				{"BlockComment", `<% x := 1 /* %> */ %>`, ` x := 1 /* %> */ `},
+				{"EscapedDelimiter", `<% x := 1 %%> %>`, ` x := 1 %> `},
+			} {
+				t.Run(tt.name, func(t *testing.T) {
+					s := ego.NewScanner(bytes.NewBufferString(tt.text), "tmpl.ego")
+					if blk, err := s.Scan(); err != nil {
+						t.Fatal(err)
+					} else if blk, ok := blk.(*ego.CodeBlock); !ok {
+						t.Fatalf("unexpected block type: %T", blk)
+					} else if blk.Content != tt.content {
+						t.Fatalf("unexpected content: %s", blk.Content)
+					}
+				})
+			}
+		})
+
+		// Ensure text after a block ending in a line comment is not part of
+		// the comment.
+		t.Run("LineCommentThenText", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<% x := 1 // note %><p>hi</p>\n<% y() %>"), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.CodeBlock); !ok || blk.Content != " x := 1 // note " {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.TextBlock); !ok || blk.Content != "<p>hi" {
+				t.Fatalf("unexpected block: %#v", blk)
+			}
+		})
+
+		t.Run("Decrement", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<% i--%>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.CodeBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != " i--" {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			} else if blk.TrimLeft || blk.TrimRight {
+				t.Fatalf("unexpected trim markers: %v, %v", blk.TrimLeft, blk.TrimRight)
+			}
+		})
+
 		t.Run("UnexpectedEOF/1", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<%`), "tmpl.ego")
-			if _, err := s.Scan(); err == nil || err.Error() != `Expected close tag, found EOF at tmpl.ego:1` {
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:3: Expected close tag, found EOF` {
 				t.Fatalf("unexpected error: %s", err)
 			}
 		})
 
 		t.Run("UnexpectedEOF/2", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<% x = 2`), "tmpl.ego")
-			if _, err := s.Scan(); err == nil || err.Error() != `Expected close tag, found EOF at tmpl.ego:1` {
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:9: Expected close tag, found EOF` {
 				t.Fatalf("unexpected error: %s", err)
 			}
 		})
 
 		t.Run("UnexpectedEOF/3", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<% x = 2 %`), "tmpl.ego")
-			if _, err := s.Scan(); err == nil || err.Error() != `Expected close tag, found EOF at tmpl.ego:1` {
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:11: Expected close tag, found EOF` {
 				t.Fatalf("unexpected error: %s", err)
 			}
 		})
 
 		t.Run("UnexpectedEOF/4", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<% x = 2 % `), "tmpl.ego")
-			if _, err := s.Scan(); err == nil || err.Error() != `Expected close tag, found EOF at tmpl.ego:1` {
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:12: Expected close tag, found EOF` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+
+		t.Run("UnexpectedEOF/String", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<% x = "2 %>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:13: Expected close tag, found EOF` {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	})
+
+	t.Run("CommentBlock", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString("<%# TODO: \"fix\" 100% %>"), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.CommentBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != ` TODO: "fix" 100% ` {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
+				t.Fatalf("unexpected pos: %#v", blk.Pos)
+			}
+		})
+
+		t.Run("UnexpectedEOF", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%# x %`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:8: Expected close tag, found EOF` {
 				t.Fatalf("unexpected error: %s", err)
 			}
 		})
 	})
 
 	t.Run("PrintBlock", func(t *testing.T) {
+		t.Run("TrimMarkers", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%-= x -%>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.PrintBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != " x " {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			} else if !blk.TrimLeft || !blk.TrimRight {
+				t.Fatalf("unexpected trim markers: %v, %v", blk.TrimLeft, blk.TrimRight)
+			}
+		})
+
 		t.Run("UnexpectedEOF", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<%=`), "tmpl.ego")
-			if _, err := s.Scan(); err == nil || err.Error() != `Expected close tag, found EOF at tmpl.ego:1` {
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:4: Expected close tag, found EOF` {
 				t.Fatalf("unexpected error: %s", err)
 			}
 		})
 	})
 
+	t.Run("RawPrintBlock", func(t *testing.T) {
+		t.Run("TrimMarkers", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<%-== x %>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.RawPrintBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if blk.Content != " x " {
+				t.Fatalf("unexpected content: %s", blk.Content)
+			} else if !blk.TrimLeft || blk.TrimRight {
+				t.Fatalf("unexpected trim markers: %v, %v", blk.TrimLeft, blk.TrimRight)
+			}
+		})
+	})
+
 	t.Run("ComponentStartBlock", func(t *testing.T) {
 		t.Run("TypeOnly", func(t *testing.T) {
 			s := ego.NewScanner(bytes.NewBufferString(`<ego:MyComponent123>`), "tmpl.ego")
@@ -111,7 +269,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "MyComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -126,7 +284,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "myComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -142,9 +300,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected field count: %d", len(blk.Fields))
 				} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 					Name:     "Foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 					Value:    "123",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected field: %#v", blk.Fields[0])
 				}
@@ -160,9 +318,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected field count: %d", len(blk.Fields))
 				} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 					Name:     "Foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 					Value:    "100.23",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected field: %#v", blk.Fields[0])
 				}
@@ -178,9 +336,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected field count: %d", len(blk.Fields))
 				} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 					Name:     "Foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 					Value:    "true",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected field: %#v", blk.Fields[0])
 				}
@@ -197,9 +355,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    `"hello \t foo!"`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -215,9 +373,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo123",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    "`hello \\t foo!`",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 23, RuneColNo: 23, Offset: 22}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -235,9 +393,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    "&util.T{X: x, Y: 12}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -253,9 +411,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    `&util.T{X: x, Y: []V{{Z:"foo"},{Z:"bar"}}}`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -271,9 +429,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected field count: %d", len(blk.Fields))
 					} else if !reflect.DeepEqual(blk.Fields[0], &ego.Field{
 						Name:     "Foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    "util.T {}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected field: %#v", blk.Fields[0])
 					}
@@ -292,9 +450,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:     "foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 					Value:    "123",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -310,9 +468,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:     "foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 					Value:    "100.23",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -328,9 +486,9 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:     "foo",
-					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 					Value:    "true",
-					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+					ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 				) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -346,7 +504,7 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:    "foo",
-					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 				}) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -362,7 +520,7 @@ func TestScanner(t *testing.T) {
 					t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 				} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 					Name:    "foo-bar",
-					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1},
+					NamePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 				}) {
 					t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 				}
@@ -379,9 +537,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    `"hello \t foo!"`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -397,9 +555,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "_foo123",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    "`hello \\t foo!`",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 24, RuneColNo: 24, Offset: 23}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -417,9 +575,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    "&util.T{X: x, Y: 12}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -435,9 +593,9 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    `&util.T{X: x, Y: []V{{Z:"foo"},{Z:"bar"}}}`,
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
@@ -453,15 +611,83 @@ func TestScanner(t *testing.T) {
 						t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
 					} else if !reflect.DeepEqual(blk.Attrs[0], &ego.Attr{
 						Name:     "foo",
-						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1},
+						NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
 						Value:    "util.T {}",
-						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1}},
+						ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 20, RuneColNo: 20, Offset: 19}},
 					) {
 						t.Fatalf("unexpected attr: %#v", blk.Attrs[0])
 					}
 				})
 			})
 		})
+
+		t.Run("WithSpread", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Component ego:attrs=r.Attrs class="x">`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.ComponentStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if len(blk.Attrs) != 1 {
+				t.Fatalf("unexpected attr count: %d", len(blk.Attrs))
+			} else if len(blk.Spreads) != 1 {
+				t.Fatalf("unexpected spread count: %d", len(blk.Spreads))
+			} else if !reflect.DeepEqual(blk.Spreads[0], &ego.Spread{
+				NamePos:  ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 16, RuneColNo: 16, Offset: 15},
+				Value:    "r.Attrs",
+				ValuePos: ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 26, RuneColNo: 26, Offset: 25}},
+			) {
+				t.Fatalf("unexpected spread: %#v", blk.Spreads[0])
+			}
+		})
+
+		t.Run("WithCond", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Component Foo=1 ego:if=ok class="x" ego:if=(n > 0)>`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.ComponentStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if len(blk.Fields) != 1 || len(blk.Attrs) != 1 {
+				t.Fatalf("unexpected field & attr count: %d, %d", len(blk.Fields), len(blk.Attrs))
+			} else if f := blk.Fields[0]; f.Cond != "ok" || f.CondPos != (ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 29, RuneColNo: 29, Offset: 28}) {
+				t.Fatalf("unexpected field: %#v", f)
+			} else if a := blk.Attrs[0]; a.Cond != "(n > 0)" || a.CondPos != (ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 49, RuneColNo: 49, Offset: 48}) {
+				t.Fatalf("unexpected attr: %#v", a)
+			}
+		})
+
+		t.Run("ErrCondWithoutField", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Component ego:if=ok>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:16: ego:if must follow a field or attribute` {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		t.Run("WithLet", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Component Foo=1 let:total="n">`), "tmpl.ego")
+			if blk, err := s.Scan(); err != nil {
+				t.Fatal(err)
+			} else if blk, ok := blk.(*ego.ComponentStartBlock); !ok {
+				t.Fatalf("unexpected block type: %T", blk)
+			} else if len(blk.Fields) != 1 || len(blk.Attrs) != 0 || len(blk.Lets) != 1 {
+				t.Fatalf("unexpected field, attr, & let count: %d, %d, %d", len(blk.Fields), len(blk.Attrs), len(blk.Lets))
+			} else if let := blk.Lets[0]; let.Name != "total" || let.Value != "n" || let.ValuePos != (ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 32, RuneColNo: 32, Offset: 31}) {
+				t.Fatalf("unexpected let: %#v", let)
+			}
+		})
+
+		t.Run("ErrLetInvalidVariable", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Component let:total="a.b">`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:26: Invalid variable name: "a.b"` {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		t.Run("ErrSpreadWithoutValue", func(t *testing.T) {
+			s := ego.NewScanner(bytes.NewBufferString(`<ego:Component ego:attrs>`), "tmpl.ego")
+			if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:16: Expected '=' after ego:attrs` {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
 	})
 
 	t.Run("ComponentEndBlock", func(t *testing.T) {
@@ -475,7 +701,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "MyComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -490,7 +716,7 @@ func TestScanner(t *testing.T) {
 				t.Fatalf("unexpected package: %s", blk.Package)
 			} else if blk.Name != "myComponent123" {
 				t.Fatalf("unexpected name: %s", blk.Name)
-			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+			} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 				t.Fatalf("unexpected pos: %#v", blk.Pos)
 			}
 		})
@@ -506,11 +732,33 @@ func TestScanner(t *testing.T) {
 			t.Fatalf("unexpected package: %s", blk.Package)
 		} else if blk.Name != "MyField123" {
 			t.Fatalf("unexpected name: %s", blk.Name)
-		} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+		} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 			t.Fatalf("unexpected pos: %#v", blk.Pos)
 		}
 	})
 
+	t.Run("AttrStartBlockWithLet", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString(`<ego::Row let:item="row" let:i=idx>`), "tmpl.ego")
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if blk, ok := blk.(*ego.AttrStartBlock); !ok {
+			t.Fatalf("unexpected block type: %T", blk)
+		} else if len(blk.Lets) != 2 {
+			t.Fatalf("unexpected let count: %d", len(blk.Lets))
+		} else if let := blk.Lets[0]; let.Name != "item" || let.Value != "row" {
+			t.Fatalf("unexpected let(0): %#v", let)
+		} else if let := blk.Lets[1]; let.Name != "i" || let.Value != "idx" {
+			t.Fatalf("unexpected let(1): %#v", let)
+		}
+	})
+
+	t.Run("ErrAttrStartBlockWithAttr", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString(`<ego::Row class="x">`), "tmpl.ego")
+		if _, err := s.Scan(); err == nil || err.Error() != `tmpl.ego:1:11: Expected '>' or let: attribute, found class` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("AttrEndBlock", func(t *testing.T) {
 		s := ego.NewScanner(bytes.NewBufferString(`</ego::_myField123>`), "tmpl.ego")
 		if blk, err := s.Scan(); err != nil {
@@ -521,7 +769,7 @@ func TestScanner(t *testing.T) {
 			t.Fatalf("unexpected package: %s", blk.Package)
 		} else if blk.Name != "_myField123" {
 			t.Fatalf("unexpected name: %s", blk.Name)
-		} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+		} else if !reflect.DeepEqual(blk.Pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 			t.Fatalf("unexpected pos: %#v", blk.Pos)
 		}
 	})
@@ -530,23 +778,36 @@ func TestScanner(t *testing.T) {
 		s := ego.NewScanner(bytes.NewBufferString("hello\nworld<%== x \n\n %>goodbye"), "tmpl.ego")
 		if blk, err := s.Scan(); err != nil {
 			t.Fatal(err)
-		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 1}) {
+		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 1, RuneColNo: 1}) {
 			t.Fatalf("unexpected pos(0): %#v", pos)
 		}
 
 		if blk, err := s.Scan(); err != nil {
 			t.Fatal(err)
-		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 2}) {
+		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 2, ColNo: 6, RuneColNo: 6, Offset: 11}) {
 			t.Fatalf("unexpected pos(1): %#v", pos)
 		}
 
 		if blk, err := s.Scan(); err != nil {
 			t.Fatal(err)
-		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 4}) {
+		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 4, ColNo: 4, RuneColNo: 4, Offset: 23}) {
 			t.Fatalf("unexpected pos(2): %#v", pos)
 		}
 	})
 
+	t.Run("MultibyteColumn", func(t *testing.T) {
+		s := ego.NewScanner(bytes.NewBufferString("héllo<%= x %>"), "tmpl.ego")
+		if _, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		}
+
+		if blk, err := s.Scan(); err != nil {
+			t.Fatal(err)
+		} else if pos := ego.Position(blk); !reflect.DeepEqual(pos, ego.Pos{Path: "tmpl.ego", LineNo: 1, ColNo: 7, RuneColNo: 6, Offset: 6}) {
+			t.Fatalf("unexpected pos: %#v", pos)
+		}
+	})
+
 	t.Run("EOF", func(t *testing.T) {
 		s := ego.NewScanner(bytes.NewBuffer(nil), "tmpl.ego")
 		if blk, err := s.Scan(); err != io.EOF {
@@ -0,0 +1,230 @@
+package ego
+
+import (
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"unicode/utf8"
+)
+
+// SourceMap maps positions in the Go code generated from a template back to
+// positions in the template. Only code copied from the template, such as
+// code blocks, print blocks, and component fields, is mapped.
+type SourceMap struct {
+	Mappings []Mapping
+}
+
+// Mapping associates a token in the generated code with the template
+// position it was copied from.
+type Mapping struct {
+	// Position in the generated file.
+	Generated token.Position
+
+	// Position reported by Go tools, which honor the //line directives
+	// in the generated file.
+	Adjusted token.Position
+
+	// Position in the template & length of the token in bytes.
+	Source Pos
+	Len    int
+
+	// Text of the token as copied from the template.
+	text string
+}
+
+// Lookup returns the template position for a line & column in the
+// generated file. Returns false if the line has no mapped tokens.
+func (m *SourceMap) Lookup(line, col int) (Pos, bool) {
+	return m.lookupSource(line, col, func(m *Mapping) (int, int) { return m.Generated.Line, m.Generated.Column })
+}
+
+// LookupAdjusted returns the template position for a line & column
+// reported by Go tools for the generated file, such as "tmpl.ego:3:12".
+// Returns false if the line has no mapped tokens.
+func (m *SourceMap) LookupAdjusted(line, col int) (Pos, bool) {
+	return m.lookupSource(line, col, func(m *Mapping) (int, int) { return m.Adjusted.Line, m.Adjusted.Column })
+}
+
+// LookupTemplate returns the position in the generated file for a line &
+// column in the template. Returns false if the position is not within or
+// immediately after a token copied to the generated file.
+func (m *SourceMap) LookupTemplate(line, col int) (token.Position, bool) {
+	mapping, d := m.find(line, col, func(m *Mapping) (int, int) { return m.Source.LineNo, m.Source.ColNo })
+	if mapping == nil || d < 0 || d > mapping.Len {
+		return token.Position{}, false
+	}
+
+	pos := mapping.Generated
+	pos.Column, pos.Offset = pos.Column+d, pos.Offset+d
+	return pos, true
+}
+
+func (m *SourceMap) lookupSource(line, col int, position func(*Mapping) (int, int)) (Pos, bool) {
+	mapping, d := m.find(line, col, position)
+	if mapping == nil {
+		return Pos{}, false
+	}
+
+	// Move to the column within the token. The byte distance is moved back
+	// to the start of a rune so rune columns are counted from the text.
+	// Columns past the end of the token, such as the end of an identifier,
+	// are counted as single bytes.
+	pos := mapping.Source
+	if d > 0 {
+		n := d
+		if n > len(mapping.text) {
+			n = len(mapping.text)
+		}
+		for n > 0 && n < len(mapping.text) && !utf8.RuneStart(mapping.text[n]) {
+			n--
+		}
+		pos = pos.advanceString(mapping.text[:n])
+		if extra := d - len(mapping.text); extra > 0 {
+			pos.ColNo, pos.RuneColNo = pos.ColNo+extra, pos.RuneColNo+extra
+		}
+	}
+	return pos, true
+}
+
+// find returns the mapping of the token at or before col on line and the
+// distance from the start of the token to col. If there is no such token,
+// the first token after col is used instead.
+func (m *SourceMap) find(line, col int, position func(*Mapping) (int, int)) (*Mapping, int) {
+	var best *Mapping
+	var bestCol int
+	for i := range m.Mappings {
+		mapping := &m.Mappings[i]
+		l, c := position(mapping)
+		if l != line {
+			continue
+		}
+
+		switch {
+		case best == nil,
+			c <= col && (bestCol > col || c > bestCol),
+			c > col && bestCol > col && c < bestCol:
+			best, bestCol = mapping, c
+		}
+	}
+	return best, col - bestCol
+}
+
+// sourceSpan represents a range of generated code copied from the template.
+type sourceSpan struct {
+	offset  int    // offset in the unformatted code
+	content string // copied code
+	pos     Pos    // position of the code in the template
+}
+
+// newSourceMap returns a source map for the formatted code, out, using the
+// spans recorded while writing the unformatted code, src.
+//
+// Formatting only changes whitespace, separators, and imports so the
+// remaining tokens of both files are matched in order.
+func newSourceMap(src, out []byte, spans []sourceSpan) (*SourceMap, error) {
+	srcTokens, _, err := scanGoTokens(src)
+	if err != nil {
+		return nil, err
+	}
+	outTokens, fset, err := scanGoTokens(out)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SourceMap{}
+	var j int
+	for _, tok := range srcTokens {
+		// Find matching token in the formatted code. Skip the token if it
+		// was removed by formatting.
+		k := j
+		for k < len(outTokens) && (outTokens[k].tok != tok.tok || outTokens[k].lit != tok.lit) {
+			k++
+		}
+		if k == len(outTokens) {
+			continue
+		}
+		j = k + 1
+
+		// Ignore tokens which were not copied from the template.
+		i := sort.Search(len(spans), func(i int) bool { return spans[i].offset > tok.offset }) - 1
+		if i < 0 || tok.offset >= spans[i].offset+len(spans[i].content) {
+			continue
+		}
+		span := spans[i]
+		start, end := tok.offset-span.offset, tok.offset-span.offset+tok.len
+		if end > len(span.content) {
+			end = len(span.content)
+		}
+
+		p := outTokens[k].pos
+		m.Mappings = append(m.Mappings, Mapping{
+			Generated: fset.PositionFor(p, false),
+			Adjusted:  fset.PositionFor(p, true),
+			Source:    span.pos.advanceString(span.content[:start]),
+			Len:       tok.len,
+			text:      span.content[start:end],
+		})
+	}
+	return m, nil
+}
+
+// goToken represents a token scanned from Go code.
+type goToken struct {
+	pos    token.Pos
+	offset int
+	len    int
+	tok    token.Token
+	lit    string
+}
+
+// scanGoTokens returns the tokens of a Go file. Import declarations, commas,
+// semicolons, and parentheses are excluded as they can be changed by
+// formatting, such as the parentheses around an if condition.
+func scanGoTokens(src []byte) ([]goToken, *token.FileSet, error) {
+	// Determine import ranges.
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	var imports [][2]int
+	for _, decl := range f.Decls {
+		imports = append(imports, [2]int{fset.PositionFor(decl.Pos(), false).Offset, fset.PositionFor(decl.End(), false).Offset})
+	}
+
+	var s scanner.Scanner
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, src, nil, 0)
+
+	var a []goToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		} else if tok == token.SEMICOLON || tok == token.COMMA || tok == token.LPAREN || tok == token.RPAREN {
+			continue
+		}
+
+		offset := file.Offset(pos)
+		if inRanges(imports, offset) {
+			continue
+		}
+
+		n := len(lit)
+		if n == 0 {
+			n = len(tok.String())
+		}
+		a = append(a, goToken{pos: pos, offset: offset, len: n, tok: tok, lit: lit})
+	}
+	return a, fset, nil
+}
+
+func inRanges(a [][2]int, offset int) bool {
+	for _, r := range a {
+		if offset >= r[0] && offset < r[1] {
+			return true
+		}
+	}
+	return false
+}
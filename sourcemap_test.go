@@ -0,0 +1,146 @@
+package ego_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that positions in the generated code map back to the template.
+func TestTemplate_SourceMap(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\n<p>Hello, <%= nmae %>!</p>\n<ego:Button Label=title ego:if=(visible) class=\"x\" ego:if=selected />\n<% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	sm, err := tmpl.SourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		ident  string
+		lineNo int
+		colNo  int
+	}{
+		{ident: "nmae", lineNo: 3, colNo: 15},
+		{ident: "Label", lineNo: 4, colNo: 13},
+		{ident: "title", lineNo: 4, colNo: 19},
+		{ident: "Button", lineNo: 4, colNo: 6},
+		{ident: "visible", lineNo: 4, colNo: 33},
+		{ident: "selected", lineNo: 4, colNo: 59},
+	} {
+		t.Run(tt.ident, func(t *testing.T) {
+			line, col := findIdent(buf.String(), tt.ident)
+			if pos, ok := sm.Lookup(line, col); !ok {
+				t.Fatalf("no mapping for %d:%d", line, col)
+			} else if pos.LineNo != tt.lineNo || pos.ColNo != tt.colNo {
+				t.Fatalf("unexpected pos: %s", pos)
+			}
+
+			// Map the template position back to the generated file.
+			if pos, ok := sm.LookupTemplate(tt.lineNo, tt.colNo+1); !ok {
+				t.Fatal("no template mapping")
+			} else if pos.Line != line || pos.Column != col+1 {
+				t.Fatalf("unexpected generated pos: %d:%d", pos.Line, pos.Column)
+			}
+
+			// Find the adjusted position reported by Go tools.
+			var found bool
+			for _, m := range sm.Mappings {
+				if m.Generated.Line == line && m.Generated.Column == col {
+					found = true
+					if pos, ok := sm.LookupAdjusted(m.Adjusted.Line, m.Adjusted.Column); !ok {
+						t.Fatal("no adjusted mapping")
+					} else if pos != m.Source {
+						t.Fatalf("unexpected adjusted pos: %s", pos)
+					}
+				}
+			}
+			if !found {
+				t.Fatal("mapping not found")
+			}
+		})
+	}
+}
+
+// findIdent returns the line & column of the last occurrence of ident in s.
+func findIdent(s, ident string) (line, col int) {
+	i := strings.LastIndex(s, ident)
+	line = strings.Count(s[:i], "\n") + 1
+	return line, i - strings.LastIndex(s[:i], "\n")
+}
+
+// Ensure that rune columns within a token count the runes before the column.
+func TestSourceMap_Lookup_MultiByte(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><p>é <%= \"ééx\" %></p><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	sm, err := tmpl.SourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Look up the "x" within the string literal.
+	line, col := findIdent(buf.String(), "x\"")
+	if pos, ok := sm.Lookup(line, col); !ok {
+		t.Fatalf("no mapping for %d:%d", line, col)
+	} else if pos.LineNo != 2 || pos.ColNo != 66 || pos.RuneColNo != 63 {
+		t.Fatalf("unexpected pos: %#v", pos)
+	}
+
+	// Look up the end of the string literal.
+	if pos, ok := sm.Lookup(line, col+2); !ok {
+		t.Fatalf("no mapping for %d:%d", line, col+2)
+	} else if pos.LineNo != 2 || pos.ColNo != 68 || pos.RuneColNo != 65 {
+		t.Fatalf("unexpected end pos: %#v", pos)
+	}
+}
+
+// Ensure that an invalid UTF-8 byte counts as a single byte & rune.
+func TestSourceMap_Lookup_InvalidUTF8(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %>\xffé<p><%= nmae %></p><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	} else if blk := tmpl.Blocks[1].(*ego.TextBlock); blk.Content != "\xffé<p>" {
+		t.Fatalf("unexpected text: %q", blk.Content)
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(buf.String(), `"\xffé<p>"`) {
+		t.Fatalf("expected invalid byte in output:\n%s", buf.String())
+	}
+	sm, err := tmpl.SourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, col := findIdent(buf.String(), "nmae")
+	if pos, ok := sm.Lookup(line, col); !ok {
+		t.Fatalf("no mapping for %d:%d", line, col)
+	} else if pos.LineNo != 2 || pos.ColNo != 61 || pos.RuneColNo != 60 || pos.Offset != 75 {
+		t.Fatalf("unexpected pos: %#v", pos)
+	}
+
+	// Invalid UTF-8 in Go code is reported at its position in the template.
+	tmpl, err = ego.Parse(strings.NewReader("<% package foo\nfunc Render(ctx context.Context, w io.Writer) { x := \"\xffé\xff\"; _ = x %><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := tmpl.WriteTo(&buf); err == nil || err.Error() != "tmpl.ego:2:55: illegal UTF-8 encoding (and 1 more errors)" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package ego
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"hash/fnv"
+	"io"
+	"sort"
+)
+
+// StaticText returns the text written from package-level byte slices when
+// the StaticText option is set. The text is listed in the order it is
+// written & may contain duplicates.
+func (t *Template) StaticText() []string {
+	_, _, g, _ := t.generate()
+	return g.texts
+}
+
+// WriteToWithStaticText writes the template to a writer like WriteTo &
+// returns the text written from package-level byte slices like StaticText.
+// The code is only generated once.
+func (t *Template) WriteToWithStaticText(w io.Writer) (n int64, texts []string, err error) {
+	src, out, g, err := t.generate()
+	if err != nil {
+		n, _ = src.WriteTo(w)
+		return n, nil, err
+	}
+	n, err = out.WriteTo(w)
+	return n, g.texts, err
+}
+
+// WriteStaticText writes a Go file for package pkg which declares the byte
+// slices used by templates generated with the StaticText option. The text
+// from every such template in the package must be included. Duplicate text
+// is declared once.
+func WriteStaticText(w io.Writer, pkg string, texts []string) error {
+	m := make(map[string]string, len(texts))
+	for _, text := range texts {
+		m[staticTextName(text)] = text
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Generated by ego.\n")
+	buf.WriteString("// DO NOT EDIT\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	if len(names) > 0 {
+		buf.WriteString("var (\n")
+		for _, name := range names {
+			fmt.Fprintf(&buf, "%s = []byte(%q)\n", name, m[name])
+		}
+		buf.WriteString(")\n")
+	}
+
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// staticTextName returns the name of the package-level byte slice holding
+// text. The name is derived from the text so it is the same in every
+// template of the package.
+func staticTextName(text string) string {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	return fmt.Sprintf("egoText%016x", h.Sum64())
+}
@@ -0,0 +1,57 @@
+package ego_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that text is written from package-level byte slices.
+func TestTemplate_StaticText(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%# ego:static %>\n<% package foo\nfunc Render(ctx context.Context, w io.Writer) { %><p><%= x %></p><%= y %><p><% } %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	} else if !tmpl.Options.StaticText {
+		t.Fatal("expected StaticText option")
+	}
+
+	var buf bytes.Buffer
+	if _, err := tmpl.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(buf.String(), "io.WriteString(w, ") {
+		t.Fatalf("unexpected string write:\n%s", buf.String())
+	} else if n := strings.Count(buf.String(), "_, _ = w.Write(egoText"); n != 3 {
+		t.Fatalf("unexpected write count: %d\n%s", n, buf.String())
+	}
+
+	if got, want := tmpl.StaticText(), []string{"<p>", "</p>", "<p>"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected text: %q", got)
+	}
+
+	// Ensure the code & text can be returned together.
+	var other bytes.Buffer
+	if _, texts, err := tmpl.WriteToWithStaticText(&other); err != nil {
+		t.Fatal(err)
+	} else if other.String() != buf.String() {
+		t.Fatalf("unexpected output:\n%s", other.String())
+	} else if got, want := texts, []string{"<p>", "</p>", "<p>"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected text: %q", got)
+	}
+}
+
+// Ensure that the byte slices are declared once for duplicate text.
+func TestWriteStaticText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ego.WriteStaticText(&buf, "foo", []string{"<p>", "</p>", "<p>"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	if !strings.Contains(s, "package foo\n") {
+		t.Fatalf("expected package clause:\n%s", s)
+	} else if strings.Count(s, `[]byte("<p>")`) != 1 || strings.Count(s, `[]byte("</p>")`) != 1 {
+		t.Fatalf("unexpected declarations:\n%s", s)
+	}
+}
@@ -0,0 +1,110 @@
+package ego
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+)
+
+// printFunc holds the call written around a printed value of a known type.
+type printFunc struct {
+	prefix, suffix string
+}
+
+// printFuncs are the calls used for printed values by their basic type.
+// They avoid converting the value to an interface which may allocate.
+var printFuncs = map[types.BasicKind]printFunc{
+	types.String:        {`ego.WriteEscapedString(w, `, ")"},
+	types.UntypedString: {`ego.WriteEscapedString(w, `, ")"},
+	types.Int:           {`ego.WriteInt(w, int64(`, "))"},
+	types.Int8:          {`ego.WriteInt(w, int64(`, "))"},
+	types.Int16:         {`ego.WriteInt(w, int64(`, "))"},
+	types.Int32:         {`ego.WriteInt(w, int64(`, "))"},
+	types.Int64:         {`ego.WriteInt(w, int64(`, "))"},
+	types.UntypedInt:    {`ego.WriteInt(w, int64(`, "))"},
+	types.UntypedRune:   {`ego.WriteInt(w, int64(`, "))"},
+	types.Uint:          {`ego.WriteUint(w, uint64(`, "))"},
+	types.Uint8:         {`ego.WriteUint(w, uint64(`, "))"},
+	types.Uint16:        {`ego.WriteUint(w, uint64(`, "))"},
+	types.Uint32:        {`ego.WriteUint(w, uint64(`, "))"},
+	types.Uint64:        {`ego.WriteUint(w, uint64(`, "))"},
+	types.Uintptr:       {`ego.WriteUint(w, uint64(`, "))"},
+	types.Float32:       {`ego.WriteFloat(w, float64(`, "), 32)"},
+	types.Float64:       {`ego.WriteFloat(w, float64(`, "), 64)"},
+	types.UntypedFloat:  {`ego.WriteFloat(w, float64(`, "), 64)"},
+	types.Bool:          {`ego.WriteBool(w, `, ")"},
+	types.UntypedBool:   {`ego.WriteBool(w, `, ")"},
+}
+
+// bytesPrintFunc is the call used for printed values of type []byte.
+var bytesPrintFunc = printFunc{`ego.WriteEscapedBytes(w, `, ")"}
+
+// findPrintFuncs returns the call to use for each value printed at the
+// given offsets in the generated code, or a blank printFunc if the type of
+// the value is not known.
+//
+// The generated file is type checked on its own without reading imported
+// packages or the rest of its package, so only types that can be found from
+// the template itself are known. This keeps the generated code the same
+// wherever it is generated.
+func findPrintFuncs(src []byte, offsets []int) []printFunc {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil
+	}
+
+	// Find the outermost expression starting at each offset.
+	exprs := make(map[int]ast.Expr, len(offsets))
+	for _, offset := range offsets {
+		exprs[offset] = nil
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if expr, ok := n.(ast.Expr); ok {
+			offset := fset.Position(expr.Pos()).Offset
+			if v, ok := exprs[offset]; ok && v == nil {
+				exprs[offset] = expr
+			}
+		}
+		return true
+	})
+
+	// Errors are expected for any references to other packages & files.
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	conf := types.Config{
+		Importer: emptyImporter{},
+		Error:    func(error) {},
+	}
+	_, _ = conf.Check(f.Name.Name, fset, []*ast.File{f}, info)
+
+	funcs := make([]printFunc, len(offsets))
+	for i, offset := range offsets {
+		expr := exprs[offset]
+		if expr == nil {
+			continue
+		}
+
+		// Named types are skipped since they may implement HTMLer,
+		// fmt.Stringer, or other interfaces that change how they print.
+		switch typ := info.Types[expr].Type.(type) {
+		case *types.Basic:
+			funcs[i] = printFuncs[typ.Kind()]
+		case *types.Slice:
+			if elem, ok := typ.Elem().(*types.Basic); ok && elem.Kind() == types.Byte {
+				funcs[i] = bytesPrintFunc
+			}
+		}
+	}
+	return funcs
+}
+
+// emptyImporter imports every package as an empty package.
+type emptyImporter struct{}
+
+func (emptyImporter) Import(importPath string) (*types.Package, error) {
+	pkg := types.NewPackage(importPath, path.Base(importPath))
+	pkg.MarkComplete()
+	return pkg, nil
+}
@@ -0,0 +1,99 @@
+package ego
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each block encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children of
+// the block with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(blk Block) (w Visitor)
+}
+
+// Walk traverses a block in depth-first order: It starts by calling
+// v.Visit(blk); blk must not be nil. If the visitor w returned by
+// v.Visit(blk) is not nil, Walk is invoked recursively with visitor w for
+// each of the non-nil children of blk, followed by a call of w.Visit(nil).
+//
+// The children of a component are its attribute blocks & content blocks in
+// the order they appear in the template.
+func Walk(v Visitor, blk Block) {
+	if v = v.Visit(blk); v == nil {
+		return
+	}
+
+	switch blk := blk.(type) {
+	case *ComponentStartBlock:
+		walkBlockList(v, componentChildren(blk))
+	case *AttrStartBlock:
+		walkBlockList(v, blk.Yield)
+	}
+
+	v.Visit(nil)
+}
+
+func walkBlockList(v Visitor, a []Block) {
+	for _, blk := range a {
+		if blk != nil {
+			Walk(v, blk)
+		}
+	}
+}
+
+type inspector func(Block) bool
+
+func (f inspector) Visit(blk Block) Visitor {
+	if f(blk) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the blocks of a template in depth-first order: It
+// starts by calling f(blk) for each top-level block; If f returns true,
+// Inspect invokes f recursively for each of the non-nil children of the
+// block, followed by a call of f(nil).
+func Inspect(t *Template, f func(Block) bool) {
+	walkBlockList(inspector(f), t.Blocks)
+}
+
+// Rewrite replaces each block in the template with the blocks returned by
+// fn. Returning nil removes the block & returning the block itself leaves it
+// unchanged. Children are rewritten before their parent block so fn receives
+// blocks with rewritten content.
+//
+// Attribute blocks of a component must be replaced by attribute blocks.
+// Adjacent text blocks are not joined after rewriting.
+func Rewrite(t *Template, fn func(Block) []Block) {
+	t.Blocks = rewriteBlockList(t.Blocks, fn)
+}
+
+func rewriteBlockList(a []Block, fn func(Block) []Block) []Block {
+	var other []Block
+	for _, blk := range a {
+		if blk != nil {
+			other = append(other, rewriteBlock(blk, fn)...)
+		}
+	}
+	return other
+}
+
+func rewriteBlock(blk Block, fn func(Block) []Block) []Block {
+	switch blk := blk.(type) {
+	case *ComponentStartBlock:
+		var attrBlocks []*AttrStartBlock
+		for _, attrBlock := range blk.AttrBlocks {
+			for _, other := range rewriteBlock(attrBlock, fn) {
+				a, ok := other.(*AttrStartBlock)
+				if !ok {
+					panic(fmt.Sprintf("ego.Rewrite: attribute block replaced with %T", other))
+				}
+				attrBlocks = append(attrBlocks, a)
+			}
+		}
+		blk.AttrBlocks = attrBlocks
+		blk.Yield = rewriteBlockList(blk.Yield, fn)
+	case *AttrStartBlock:
+		blk.Yield = rewriteBlockList(blk.Yield, fn)
+	}
+	return fn(blk)
+}
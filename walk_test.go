@@ -0,0 +1,82 @@
+package ego_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/ego"
+)
+
+// Ensure that all blocks are visited in depth-first order.
+func TestInspect(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<% x := 1 %><ego:A><ego::B><%= x %></ego::B><ego:C /></ego:A><%# c %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	ego.Inspect(tmpl, func(blk ego.Block) bool {
+		a = append(a, fmt.Sprintf("%T", blk))
+		return true
+	})
+	if !reflect.DeepEqual(a, []string{
+		"*ego.CodeBlock", "<nil>",
+		"*ego.ComponentStartBlock",
+		"*ego.AttrStartBlock",
+		"*ego.PrintBlock", "<nil>",
+		"<nil>",
+		"*ego.ComponentStartBlock", "<nil>",
+		"<nil>",
+		"*ego.CommentBlock", "<nil>",
+	}) {
+		t.Fatalf("unexpected blocks: %v", a)
+	}
+}
+
+// Ensure that children are not visited if the function returns false.
+func TestInspect_Skip(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<ego:A><%= x %></ego:A><%= y %>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	ego.Inspect(tmpl, func(blk ego.Block) bool {
+		if _, ok := blk.(*ego.PrintBlock); ok {
+			n++
+		}
+		_, ok := blk.(*ego.ComponentStartBlock)
+		return !ok
+	})
+	if n != 1 {
+		t.Fatalf("unexpected print block count: %d", n)
+	}
+}
+
+// Ensure that blocks can be replaced & removed at any depth.
+func TestRewrite(t *testing.T) {
+	tmpl, err := ego.Parse(strings.NewReader("<%# c %><p><%= x %></p><ego:A><ego::B><%= y %></ego::B><%# d %></ego:A>"), "tmpl.ego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ego.Rewrite(tmpl, func(blk ego.Block) []ego.Block {
+		switch blk := blk.(type) {
+		case *ego.CommentBlock:
+			return nil
+		case *ego.PrintBlock:
+			return []ego.Block{&ego.RawPrintBlock{Pos: blk.Pos, Content: blk.Content}}
+		}
+		return []ego.Block{blk}
+	})
+
+	var buf bytes.Buffer
+	if err := ego.Fprint(&buf, tmpl); err != nil {
+		t.Fatal(err)
+	} else if got, want := buf.String(), "<p><%== x %></p><ego:A><ego::B><%== y %></ego::B></ego:A>\n"; got != want {
+		t.Fatalf("unexpected template: %s", got)
+	}
+}